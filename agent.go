@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"polydawn.net/grypt/internal/stream"
+)
+
+// Environment variable naming the agent's socket, overriding the default
+// in a private directory under the system temp dir.
+const AgentEnv = "GRYPT_AGENT_SOCK"
+
+// Agent operations
+const (
+	// Look up the unlocked contents of a key file
+	agentGet = iota
+	// Hold the unlocked contents of a key file
+	agentAdd
+	// Drop every key
+	agentForget
+)
+
+// agentRequest is sent to the agent as DER, one per connection. Key files
+// are named by absolute path and the hash of their locked contents, so a
+// key file that changes on disk is not answered from the cache.
+type agentRequest struct {
+	Op   int
+	File string
+	Sum  []byte
+	Keys []byte
+}
+
+type agentReply struct {
+	OK   bool
+	Keys []byte
+}
+
+// Largest message the agent reads
+const agentMaxMessage = 64 * 1024
+
+// read one DER encoded message from r
+func readMessage(r io.Reader) ([]byte, error) {
+	bits, err := stream.ReadDER(r, agentMaxMessage)
+	if err == stream.ErrMalformed {
+		return nil, fmt.Errorf("malformed agent message")
+	}
+	return bits, err
+}
+
+// the agent's socket path
+func agentSocket() string {
+	if s := os.Getenv(AgentEnv); s != "" {
+		return s
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("grypt-%d", os.Getuid()), "agent.sock")
+}
+
+// send 'req' to the agent. Fails quietly when no agent is running.
+func askAgent(req agentRequest) (agentReply, error) {
+	var reply agentReply
+	conn, err := net.Dial("unix", agentSocket())
+	if err != nil {
+		return reply, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	bits, err := asn1.Marshal(req)
+	if err != nil {
+		return reply, err
+	}
+	if _, err = conn.Write(bits); err != nil {
+		return reply, err
+	}
+	if bits, err = readMessage(conn); err != nil {
+		return reply, err
+	}
+	_, err = asn1.Unmarshal(bits, &reply)
+	return reply, err
+}
+
+// fetch the unlocked contents of key file 'f' from the agent
+func agentKeys(f string, locked []byte) ([]byte, bool) {
+	sum := sha256.Sum256(locked)
+	reply, err := askAgent(agentRequest{Op: agentGet, File: f, Sum: sum[:]})
+	if err != nil || !reply.OK {
+		return nil, false
+	}
+	return reply.Keys, true
+}
+
+// hand the unlocked contents of key file 'f' to the agent, if one is running
+func agentStore(f string, locked, keys []byte) {
+	sum := sha256.Sum256(locked)
+	askAgent(agentRequest{Op: agentAdd, File: f, Sum: sum[:], Keys: keys})
+}
+
+type agentEntry struct {
+	keys  []byte
+	timer *time.Timer
+}
+
+// keyAgent holds unlocked key files until their timeout passes
+type keyAgent struct {
+	sync.Mutex
+	timeout time.Duration
+	keys    map[string]*agentEntry
+}
+
+func newKeyAgent(timeout time.Duration) *keyAgent {
+	return &keyAgent{timeout: timeout, keys: map[string]*agentEntry{}}
+}
+
+func (a *keyAgent) handle(req agentRequest) agentReply {
+	a.Lock()
+	defer a.Unlock()
+	id := fmt.Sprintf("%s\x00%x", req.File, req.Sum)
+	switch req.Op {
+	case agentGet:
+		if e, ok := a.keys[id]; ok {
+			return agentReply{true, append([]byte(nil), e.keys...)}
+		}
+	case agentAdd:
+		a.drop(id)
+		a.keys[id] = &agentEntry{req.Keys, time.AfterFunc(a.timeout, func() {
+			a.Lock()
+			defer a.Unlock()
+			a.drop(id)
+		})}
+		return agentReply{OK: true}
+	case agentForget:
+		for id := range a.keys {
+			a.drop(id)
+		}
+		return agentReply{OK: true}
+	}
+	return agentReply{}
+}
+
+// forget entry 'id' and wipe its keys. Caller holds the lock.
+func (a *keyAgent) drop(id string) {
+	e, ok := a.keys[id]
+	if !ok {
+		return
+	}
+	e.timer.Stop()
+	for n := range e.keys {
+		e.keys[n] = 0
+	}
+	delete(a.keys, id)
+}
+
+func (a *keyAgent) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			bits, err := readMessage(conn)
+			if err != nil {
+				return
+			}
+			var req agentRequest
+			if _, err = asn1.Unmarshal(bits, &req); err != nil {
+				return
+			}
+			if bits, err = asn1.Marshal(a.handle(req)); err == nil {
+				conn.Write(bits)
+			}
+		}()
+	}
+}
+
+// listen on the agent socket, in a directory only this user can enter
+func listenAgent(sock string) (net.Listener, error) {
+	dir := filepath.Dir(sock)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if fi.Mode().Perm()&077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users", dir)
+	}
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("an agent is already listening on %s", sock)
+	}
+	os.Remove(sock)
+	return net.Listen("unix", sock)
+}
+
+// run the agent in the foreground until interrupted
+func agent() error {
+	sock := agentSocket()
+	l, err := listenAgent(sock)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sock)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		os.Remove(sock)
+		os.Exit(0)
+	}()
+	fmt.Fprintf(os.Stderr, "grypt agent listening on %s\n", sock)
+	return newKeyAgent(*agentTimeout).serve(l)
+}
+
+// make the agent drop every key it holds
+func forget() error {
+	reply, err := askAgent(agentRequest{Op: agentForget})
+	if err != nil {
+		return fmt.Errorf("no agent: %v", err)
+	}
+	if !reply.OK {
+		return fmt.Errorf("agent refused to forget keys")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"polydawn.net/grypt/grypt"
+)
+
+func TestAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(AgentEnv, os.Getenv(AgentEnv))
+	os.Setenv(AgentEnv, filepath.Join(dir, "agent.sock"))
+	l, err := listenAgent(agentSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := newKeyAgent(time.Minute)
+	go a.serve(l)
+	timeout := func(d time.Duration) {
+		a.Lock()
+		a.timeout = d
+		a.Unlock()
+	}
+
+	locked, unlocked := []byte("locked"), []byte("unlocked")
+	if _, ok := agentKeys("/k", locked); ok {
+		t.Fatal("empty agent answered")
+	}
+	agentStore("/k", locked, unlocked)
+	if got, ok := agentKeys("/k", locked); !ok || !bytes.Equal(got, unlocked) {
+		t.Fatalf("agent did not hold the keys: %q", got)
+	}
+	if _, ok := agentKeys("/k", []byte("changed")); ok {
+		t.Error("agent answered for a key file that changed")
+	}
+	if _, ok := agentKeys("/other", locked); ok {
+		t.Error("agent answered for another key file")
+	}
+	timeout(50 * time.Millisecond)
+	agentStore("/k", locked, unlocked)
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := agentKeys("/k", locked); ok {
+		t.Error("agent held the keys past the timeout")
+	}
+
+	timeout(time.Minute)
+	agentStore("/k", locked, unlocked)
+	if err = forget(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := agentKeys("/k", locked); ok {
+		t.Error("agent held the keys after forget")
+	}
+
+	// a locked key file opens from the agent without its passphrase
+	f := filepath.Join(dir, "key")
+	if err = WriteKeyRing(f, grypt.KeyRing{keys[0]}); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := grypt.NewKDFParams(rand.Reader, 1<<10, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lockKeyFile(f, []byte("hunter2"), kp); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv(PassphraseEnv, os.Getenv(PassphraseEnv))
+	defer func() { passphrases = map[string][]byte{} }()
+	passphrases = map[string][]byte{}
+	os.Setenv(PassphraseEnv, "hunter2")
+	if _, err = ReadKeyRing(f); err != nil {
+		t.Fatal(err)
+	}
+	passphrases = map[string][]byte{}
+	os.Setenv(PassphraseEnv, "wrong")
+	ring, err := ReadKeyRing(f)
+	if err != nil {
+		t.Fatalf("agent did not unlock the key file: %v", err)
+	}
+	if !bytes.Equal(ring[0].Secret, keys[0].Secret) {
+		t.Error("agent returned the wrong key")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// The .gitattributes at the top of the work tree, which track and untrack
+// edit
+const AttributesFile = ".gitattributes"
+
+// attributes that track gives a pattern
+var gryptAttributes = []string{"filter=grypt", "diff=grypt", "merge=grypt"}
+
+// add 'pattern' to AttributesFile and re-stage the files it matches, so
+// they are encrypted in the index right away
+func track(pattern string) error {
+	lines, err := startAttributes(pattern, "track")
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if tracks(line, pattern) {
+			fmt.Fprintf(os.Stderr, "%s is already tracked\n", pattern)
+			return nil
+		}
+	}
+	line := pattern + " " + strings.Join(gryptAttributes, " ") + "\n"
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		line = "\n" + line
+	}
+	return changeAttributes(pattern, append(lines, line))
+}
+
+// drop the grypt attributes from 'pattern' in AttributesFile and re-stage
+// the files it matched, so they are stored in the clear again
+func untrack(pattern string) error {
+	lines, err := startAttributes(pattern, "untrack")
+	if err != nil {
+		return err
+	}
+	var kept []string
+	removed := 0
+	for _, line := range lines {
+		if !tracks(line, pattern) {
+			kept = append(kept, line)
+			continue
+		}
+		removed++
+		var rest []string
+		for _, f := range strings.Fields(line) {
+			if !contains(gryptAttributes, f) {
+				rest = append(rest, f)
+			}
+		}
+		if len(rest) > 1 {
+			kept = append(kept, strings.Join(rest, " ")+"\n")
+		}
+	}
+	if removed == 0 {
+		return fmt.Errorf("%s is not tracked in %s", pattern, AttributesFile)
+	}
+	return changeAttributes(pattern, kept)
+}
+
+// check that track or untrack can go ahead, and read AttributesFile as
+// lines that keep their newlines
+func startAttributes(pattern, action string) ([]string, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("no pattern given")
+	}
+	if err := chdirTop(); err != nil {
+		return nil, err
+	}
+	if _, err := git("config", "filter.grypt.clean"); err != nil {
+		return nil, fmt.Errorf("grypt is not set up in this repository, run `grypt init' first")
+	}
+	if err := requireClean(action); err != nil {
+		return nil, err
+	}
+	bits, err := ioutil.ReadFile(AttributesFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(bits), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// reports whether an attributes line gives 'pattern' the grypt filter
+func tracks(line, pattern string) bool {
+	fields := strings.Fields(line)
+	return len(fields) > 0 && fields[0] == pattern && contains(fields[1:], "filter=grypt")
+}
+
+// Write 'lines' to AttributesFile and re-stage the files whose filter that
+// changes. If it changes none, 'pattern' was probably a mistake, and the
+// file is left as it was.
+func changeAttributes(pattern string, lines []string) error {
+	old, err := ioutil.ReadFile(AttributesFile)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := git("ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return err
+	}
+	files := splitZ(out)
+	before, err := grypted(files)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(AttributesFile, []byte(strings.Join(lines, "")), 0644); err != nil {
+		return err
+	}
+	after, err := grypted(files)
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]bool)
+	for _, f := range before {
+		changed[f] = true
+	}
+	for _, f := range after {
+		changed[f] = !changed[f]
+	}
+	for f, c := range changed {
+		if !c {
+			delete(changed, f)
+		}
+	}
+	if len(changed) == 0 {
+		if !existed {
+			os.Remove(AttributesFile)
+		} else {
+			ioutil.WriteFile(AttributesFile, old, 0644)
+		}
+		return fmt.Errorf("%s matches no files", pattern)
+	}
+
+	if out, err = git("ls-files", "-z", "--cached"); err != nil {
+		return err
+	}
+	var staged []string
+	for _, f := range splitZ(out) {
+		if changed[f] {
+			staged = append(staged, f)
+			fmt.Println(f)
+		}
+	}
+	if len(staged) > 0 {
+		if _, err = git(append([]string{"--literal-pathspecs", "add", "--renormalize", "--"}, staged...)...); err != nil {
+			return err
+		}
+	}
+	_, err = git("add", "--", AttributesFile)
+	return err
+}
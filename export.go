@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// A paper backup is the keys' DER, one after the other, in base32 lines
+// meant to be typed back in:
+//
+//	1/4: AAAA BBBB CCCC DDDD EEEE FFFF  SUMS
+//
+// Each line carries its number, the number of lines, and a checksum over
+// both and its data, so a typo is caught on the line it is made.
+const (
+	paperGroup = 4
+	// groups per line: 15 bytes, so that every line but the last
+	// decodes on its own
+	paperGroups  = 6
+	paperSumSize = 4
+)
+
+var paperEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// a line that is not a backup line at all, such as a header of the
+// printout
+var errNotPaper = errors.New("not a line of a paper backup")
+
+// print the keys in keyfile as a paper backup, or as a QR code for each
+// key with -format=qr
+func export() error {
+	ring, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return err
+	}
+	switch *exportFormat {
+	case "paper":
+		return writePaper(os.Stdout, ring)
+	case "qr":
+		for _, k := range ring {
+			bits, err := grypt.MarshalKey(k)
+			if err != nil {
+				return err
+			}
+			// the code holds a line of the key file, which scanners
+			// hand back as text
+			q, err := newQRCode([]byte(base64.StdEncoding.EncodeToString(bits)))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%x  %s\n", k.ID(), k.Scheme)
+			if _, err = q.WriteTo(os.Stdout); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown export format %q, expected paper or qr", *exportFormat)
+}
+
+func writePaper(w io.Writer, ring grypt.KeyRing) error {
+	var der []byte
+	for _, k := range ring {
+		bits, err := grypt.MarshalKey(k)
+		if err != nil {
+			return err
+		}
+		der = append(der, bits...)
+	}
+	fmt.Fprintf(w, "grypt key backup\n\n")
+	for n, k := range ring {
+		use := "decrypt"
+		if n == 0 {
+			use = "encrypt"
+		}
+		fmt.Fprintf(w, "%x  %-24s %s\n", k.ID(), k.Scheme, use)
+	}
+	fmt.Fprintf(w, "\nRestore with `grypt import KEYFILE', typing in each numbered line.\n\n")
+	for _, line := range paperLines(der) {
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// split 'bits' into numbered, checksummed lines
+func paperLines(bits []byte) []string {
+	text := paperEncoding.EncodeToString(bits)
+	per := paperGroup * paperGroups
+	total := (len(text) + per - 1) / per
+	width := len(strconv.Itoa(total))
+	var lines []string
+	for n := 1; len(text) > 0; n++ {
+		data := text
+		if len(data) > per {
+			data = data[:per]
+		}
+		text = text[len(data):]
+		var groups []string
+		for i := 0; i < len(data); i += paperGroup {
+			end := i + paperGroup
+			if end > len(data) {
+				end = len(data)
+			}
+			groups = append(groups, data[i:end])
+		}
+		lines = append(lines, fmt.Sprintf("%*d/%d: %-*s  %s",
+			width, n, total, per+paperGroups-1, strings.Join(groups, " "), paperSum(n, total, data)))
+	}
+	return lines
+}
+
+func paperSum(n, total int, data string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d/%d:%s", n, total, data)))
+	return paperEncoding.EncodeToString(sum[:])[:paperSumSize]
+}
+
+// letters that are easily typed for the base32 ones
+var paperTypos = strings.NewReplacer("0", "O", "1", "I", "8", "B")
+
+// parse and check one line of a paper backup
+func parsePaperLine(line string) (n, total int, data string, err error) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return 0, 0, "", errNotPaper
+	}
+	num := strings.SplitN(strings.TrimSpace(line[:colon]), "/", 2)
+	if len(num) != 2 {
+		return 0, 0, "", errNotPaper
+	}
+	if n, err = strconv.Atoi(num[0]); err != nil {
+		return 0, 0, "", errNotPaper
+	}
+	if total, err = strconv.Atoi(num[1]); err != nil {
+		return 0, 0, "", errNotPaper
+	}
+	if n < 1 || n > total {
+		return 0, 0, "", fmt.Errorf("line %d/%d does not exist", n, total)
+	}
+	fields := strings.Fields(paperTypos.Replace(strings.ToUpper(line[colon+1:])))
+	if len(fields) < 2 {
+		return 0, 0, "", fmt.Errorf("line %d/%d is missing its data or checksum", n, total)
+	}
+	data = strings.Join(fields[:len(fields)-1], "")
+	if fields[len(fields)-1] != paperSum(n, total, data) {
+		return 0, 0, "", fmt.Errorf("line %d/%d does not match its checksum", n, total)
+	}
+	return n, total, data, nil
+}
+
+// read a paper backup from r, until it has every line. Lines that fail
+// their checksum are reported and can be typed again; with 'prompt' the
+// reader is asked for each.
+func readPaper(r io.Reader, prompt bool) (grypt.KeyRing, error) {
+	lines := map[int]string{}
+	total := 0
+	in := bufio.NewScanner(r)
+	for total == 0 || len(lines) < total {
+		if prompt {
+			fmt.Fprint(os.Stderr, "> ")
+		}
+		if !in.Scan() {
+			break
+		}
+		n, t, data, err := parsePaperLine(in.Text())
+		if err == errNotPaper {
+			continue
+		}
+		if err == nil && total != 0 && t != total {
+			err = fmt.Errorf("line %d/%d is from a backup of %d lines, not %d", n, t, t, total)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v; type it again\n", err)
+			continue
+		}
+		total, lines[n] = t, data
+	}
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no lines of a paper backup read")
+	}
+	var missing []string
+	for n := 1; n <= total; n++ {
+		if _, ok := lines[n]; !ok {
+			missing = append(missing, strconv.Itoa(n))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing line %s of %d", strings.Join(missing, ", "), total)
+	}
+	var text string
+	for n := 1; n <= total; n++ {
+		text += lines[n]
+	}
+	der, err := paperEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	var ring grypt.KeyRing
+	for len(der) > 0 {
+		var raw asn1.RawValue
+		if der, err = asn1.Unmarshal(der, &raw); err != nil {
+			return nil, err
+		}
+		k, err := grypt.UnmarshalKey(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		ring = append(ring, k)
+	}
+	if len(ring) == 0 {
+		return nil, grypt.ErrNoKeys
+	}
+	return ring, nil
+}
+
+// write the keys of a paper backup read from stdin into keyfile, which
+// must not exist yet
+func importKeys() error {
+	return writeNewKeyFile(func() (grypt.KeyRing, error) {
+		prompt := false
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			prompt = true
+			fmt.Fprintln(os.Stderr, "Type in the numbered lines of the backup, in any order.")
+		}
+		return readPaper(os.Stdin, prompt)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"polydawn.net/grypt/grypt"
+)
+
+func TestPaper(t *testing.T) {
+	ring := grypt.KeyRing(keys)
+	buf := new(bytes.Buffer)
+	if err := writePaper(buf, ring); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if _, _, _, err := parsePaperLine(l); err == nil {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) < 3 {
+		t.Fatalf("expected a few backup lines, got:\n%s", buf)
+	}
+
+	// a typo is caught, and the line can be typed again
+	typo := []byte(lines[1])
+	typo[len(typo)-8] ^= 'A' ^ 'B'
+	if _, _, _, err := parsePaperLine(string(typo)); err == nil || err == errNotPaper {
+		t.Fatalf("a typo in %q went unnoticed: %v", typo, err)
+	}
+	input := []string{"grypt key backup", "", lines[1], string(typo), lines[0]}
+	for _, l := range lines[2:] {
+		input = append(input, strings.ToLower(l))
+	}
+	got, err := readPaper(strings.NewReader(strings.Join(input, "\n")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(ring) {
+		t.Fatalf("expected %d keys back, got %d", len(ring), len(got))
+	}
+	for n, k := range ring {
+		if got[n].Version != k.Version || got[n].Scheme != k.Scheme || !bytes.Equal(got[n].Secret, k.Secret) {
+			t.Errorf("key %d changed after a round trip through paper", n)
+		}
+	}
+
+	_, err = readPaper(strings.NewReader(strings.Join(lines[1:], "\n")), false)
+	if err == nil || !strings.Contains(err.Error(), "missing line 1 ") {
+		t.Errorf("expected the first line to be missed, got %v", err)
+	}
+}
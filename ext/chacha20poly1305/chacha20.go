@@ -0,0 +1,85 @@
+package chacha20poly1305
+
+import "encoding/binary"
+
+// state is a ChaCha20 block function input; word 12 is the block counter.
+type state [16]uint32
+
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d ^= a
+	d = d<<16 | d>>16
+	c += d
+	b ^= c
+	b = b<<12 | b>>20
+	a += b
+	d ^= a
+	d = d<<8 | d>>24
+	c += d
+	b ^= c
+	b = b<<7 | b>>25
+	return a, b, c, d
+}
+
+// rounds runs the 20 ChaCha rounds over x in place.
+func rounds(x *state) {
+	for i := 0; i < 10; i++ {
+		x[0], x[4], x[8], x[12] = quarterRound(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = quarterRound(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = quarterRound(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = quarterRound(x[3], x[7], x[11], x[15])
+		x[0], x[5], x[10], x[15] = quarterRound(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = quarterRound(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = quarterRound(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = quarterRound(x[3], x[4], x[9], x[14])
+	}
+}
+
+// block writes the keystream block for the current counter to out and
+// advances the counter.
+func (s *state) block(out *[64]byte) {
+	x := *s
+	rounds(&x)
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+s[i])
+	}
+	s[12]++
+}
+
+// polyKey returns the one-time Poly1305 key from block 0, leaving the
+// counter at 1 for the message itself.
+func (s *state) polyKey() (key [32]byte) {
+	var b [64]byte
+	s.block(&b)
+	copy(key[:], b[:32])
+	return key
+}
+
+func (s *state) xor(dst, src []byte) {
+	var b [64]byte
+	for len(src) > 0 {
+		s.block(&b)
+		n := len(src)
+		if n > len(b) {
+			n = len(b)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ b[i]
+		}
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// hchacha20 derives the XChaCha20 subkey from key and the first 16 bytes
+// of the extended nonce.
+func hchacha20(key *[8]uint32, nonce []byte) (out [8]uint32) {
+	x := state{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574}
+	copy(x[4:12], key[:])
+	for i := 0; i < 4; i++ {
+		x[12+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	rounds(&x)
+	copy(out[:4], x[:4])
+	copy(out[4:], x[12:])
+	return out
+}
@@ -0,0 +1,142 @@
+// Package chacha20poly1305 implements the ChaCha20-Poly1305 AEAD of RFC 8439
+// and its XChaCha20-Poly1305 variant with 24 byte nonces.
+package chacha20poly1305
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"code.google.com/p/go.crypto/poly1305"
+)
+
+const (
+	// KeySize is the size of the key used by this AEAD.
+	KeySize = 32
+	// NonceSize is the size of the nonce used by New.
+	NonceSize = 12
+	// NonceSizeX is the size of the nonce used by NewX.
+	NonceSizeX = 24
+	// Overhead is the size of the Poly1305 tag appended to each message.
+	Overhead = 16
+	// Largest plaintext before the 32-bit block counter wraps.
+	maxPlaintext = (1<<32 - 1) * 64
+)
+
+var errOpen = errors.New("chacha20poly1305: message authentication failed")
+
+type chacha20poly1305 struct {
+	key [8]uint32
+	x   bool
+}
+
+// New returns a ChaCha20-Poly1305 cipher.AEAD that takes 12 byte nonces.
+func New(key []byte) (cipher.AEAD, error) {
+	return newAEAD(key, false)
+}
+
+// NewX returns an XChaCha20-Poly1305 cipher.AEAD that takes 24 byte nonces,
+// which are long enough to be chosen at random.
+func NewX(key []byte) (cipher.AEAD, error) {
+	return newAEAD(key, true)
+}
+
+func newAEAD(key []byte, x bool) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("chacha20poly1305: bad key length")
+	}
+	c := &chacha20poly1305{x: x}
+	for i := range c.key {
+		c.key[i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	return c, nil
+}
+
+func (c *chacha20poly1305) NonceSize() int {
+	if c.x {
+		return NonceSizeX
+	}
+	return NonceSize
+}
+
+func (c *chacha20poly1305) Overhead() int { return Overhead }
+
+func (c *chacha20poly1305) Seal(dst, nonce, plaintext, data []byte) []byte {
+	if len(nonce) != c.NonceSize() {
+		panic("chacha20poly1305: bad nonce length passed to Seal")
+	}
+	if uint64(len(plaintext)) > maxPlaintext {
+		panic("chacha20poly1305: plaintext too large")
+	}
+	s := c.stream(nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+Overhead)
+	polyKey := s.polyKey()
+	s.xor(out[:len(plaintext)], plaintext)
+	var tag [Overhead]byte
+	poly1305.Sum(&tag, macData(data, out[:len(plaintext)]), &polyKey)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (c *chacha20poly1305) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	if len(nonce) != c.NonceSize() {
+		panic("chacha20poly1305: bad nonce length passed to Open")
+	}
+	if len(ciphertext) < Overhead || uint64(len(ciphertext)) > maxPlaintext+Overhead {
+		return nil, errOpen
+	}
+	var tag [Overhead]byte
+	copy(tag[:], ciphertext[len(ciphertext)-Overhead:])
+	ciphertext = ciphertext[:len(ciphertext)-Overhead]
+	s := c.stream(nonce)
+	polyKey := s.polyKey()
+	if !poly1305.Verify(&tag, macData(data, ciphertext), &polyKey) {
+		return nil, errOpen
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	s.xor(out, ciphertext)
+	return ret, nil
+}
+
+// stream sets up the ChaCha20 state for nonce, going through HChaCha20
+// first for the extended variant.
+func (c *chacha20poly1305) stream(nonce []byte) *state {
+	key := c.key
+	if c.x {
+		key = hchacha20(&key, nonce[:16])
+		nonce = append(make([]byte, 4), nonce[16:]...)
+	}
+	s := &state{}
+	s[0], s[1], s[2], s[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	copy(s[4:12], key[:])
+	for i := 0; i < 3; i++ {
+		s[13+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	return s
+}
+
+// macData lays out the Poly1305 input: the additional data and ciphertext,
+// each padded to 16 bytes, followed by their lengths.
+func macData(data, ciphertext []byte) []byte {
+	pad := func(n int) int { return (16 - n%16) % 16 }
+	m := make([]byte, 0, len(data)+pad(len(data))+len(ciphertext)+pad(len(ciphertext))+16)
+	m = append(m, data...)
+	m = append(m, make([]byte, pad(len(data)))...)
+	m = append(m, ciphertext...)
+	m = append(m, make([]byte, pad(len(ciphertext)))...)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(data)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	return append(m, lengths[:]...)
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
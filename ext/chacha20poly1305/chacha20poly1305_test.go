@@ -0,0 +1,84 @@
+package chacha20poly1305
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+)
+
+var sunscreen = []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+
+// RFC 8439 section 2.8.2, and appendix A.3.1 of the XChaCha20 draft
+// (draft-irtf-cfrg-xchacha).
+var golden = []struct {
+	x                bool
+	key, nonce, data string
+	ciphertext, tag  string
+}{
+	{
+		false,
+		"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		"070000004041424344454647",
+		"50515253c0c1c2c3c4c5c6c7",
+		"d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e4b7a9de576d26586cec64b6116",
+		"1ae10b594f09e26a7e902ecbd0600691",
+	},
+	{
+		true,
+		"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		"404142434445464748494a4b4c4d4e4f5051525354555657",
+		"50515253c0c1c2c3c4c5c6c7",
+		"bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b4522f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff921f9664c97637da9768812f615c68b13b52e",
+		"c0875924c1c7987947deafd8780acf49",
+	},
+}
+
+func decode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestGolden(t *testing.T) {
+	for i, v := range golden {
+		var a cipher.AEAD
+		var err error
+		if v.x {
+			a, err = NewX(decode(v.key))
+		} else {
+			a, err = New(decode(v.key))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce, data := decode(v.nonce), decode(v.data)
+		want := v.ciphertext + v.tag
+		ct := a.Seal(nil, nonce, sunscreen, data)
+		if hex.EncodeToString(ct) != want {
+			t.Errorf("%d: Seal():\nexpected %s\ngot      %x", i, want, ct)
+		}
+		pt, err := a.Open(nil, nonce, ct, data)
+		if err != nil {
+			t.Errorf("%d: Open(): %v", i, err)
+		} else if !bytes.Equal(pt, sunscreen) {
+			t.Errorf("%d: Open():\nexpected %x\ngot      %x", i, sunscreen, pt)
+		}
+		ct[len(ct)-1] ^= 1
+		if _, err := a.Open(nil, nonce, ct, data); err == nil {
+			t.Errorf("%d: Open() accepted a modified tag", i)
+		}
+	}
+}
+
+func BenchmarkSealX1K(b *testing.B) {
+	a, _ := NewX(make([]byte, KeySize))
+	nonce := make([]byte, NonceSizeX)
+	buf := make([]byte, 1024)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		a.Seal(buf[:0], nonce, buf, nil)
+	}
+}
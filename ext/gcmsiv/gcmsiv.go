@@ -0,0 +1,153 @@
+// Package gcmsiv implements the AES-GCM-SIV authenticated encryption mode
+// described in RFC 8452.
+//
+// GCM-SIV derives its keystream from a tag over the plaintext, so reusing a
+// nonce only reveals whether two messages were identical.
+package gcmsiv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// NonceSize is the size of the nonce passed to Seal and Open.
+	NonceSize = 12
+	// TagSize is the number of bytes Seal appends to the plaintext.
+	TagSize = 16
+	// Largest plaintext the mode is defined for (2^36 bytes).
+	maxPlaintext = 1 << 36
+)
+
+var errOpen = errors.New("gcmsiv: message authentication failed")
+
+type gcmsiv struct {
+	block cipher.Block
+	key   []byte
+}
+
+// New returns an AES-GCM-SIV cipher.AEAD for a 16 or 32 byte key.
+func New(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, errors.New("gcmsiv: invalid key size")
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmsiv{b, append([]byte{}, key...)}, nil
+}
+
+func (g *gcmsiv) NonceSize() int { return NonceSize }
+
+func (g *gcmsiv) Overhead() int { return TagSize }
+
+func (g *gcmsiv) Seal(dst, nonce, plaintext, data []byte) []byte {
+	if len(nonce) != NonceSize {
+		panic("gcmsiv: incorrect nonce length")
+	}
+	if uint64(len(plaintext)) > maxPlaintext || uint64(len(data)) > maxPlaintext {
+		panic("gcmsiv: message too large")
+	}
+	authKey, enc := g.deriveKeys(nonce)
+	tag := g.tag(authKey, enc, nonce, plaintext, data)
+	ret, out := sliceForAppend(dst, len(plaintext)+TagSize)
+	ctr(enc, tag[:], out[:len(plaintext)], plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (g *gcmsiv) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		panic("gcmsiv: incorrect nonce length")
+	}
+	if len(ciphertext) < TagSize || uint64(len(ciphertext)) > maxPlaintext+TagSize {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-TagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-TagSize]
+	authKey, enc := g.deriveKeys(nonce)
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	ctr(enc, tag, out, ciphertext)
+	expected := g.tag(authKey, enc, nonce, out, data)
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// deriveKeys computes the per-nonce authentication and encryption keys.
+func (g *gcmsiv) deriveKeys(nonce []byte) (authKey [16]byte, enc cipher.Block) {
+	var in, out [16]byte
+	encKey := make([]byte, len(g.key))
+	copy(in[4:], nonce)
+	for i := 0; i < 2+len(encKey)/8; i++ {
+		binary.LittleEndian.PutUint32(in[:4], uint32(i))
+		g.block.Encrypt(out[:], in[:])
+		if i < 2 {
+			copy(authKey[i*8:], out[:8])
+		} else {
+			copy(encKey[(i-2)*8:], out[:8])
+		}
+	}
+	enc, _ = aes.NewCipher(encKey)
+	return authKey, enc
+}
+
+// tag computes the GCM-SIV tag over the additional data and plaintext.
+func (g *gcmsiv) tag(authKey [16]byte, enc cipher.Block, nonce, plaintext, data []byte) (tag [16]byte) {
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(data))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	p := newPolyval(authKey)
+	p.update(data)
+	p.update(plaintext)
+	p.update(lengths[:])
+	s := p.sum()
+	for i := range nonce {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	enc.Encrypt(tag[:], s[:])
+	return tag
+}
+
+// ctr XORs src with the keystream that starts from the counter block
+// derived from tag. Unlike GCM the 32-bit counter is little-endian and
+// occupies the first four bytes of the block.
+func ctr(enc cipher.Block, tag, dst, src []byte) {
+	var block, ks [16]byte
+	copy(block[:], tag)
+	block[15] |= 0x80
+	n := binary.LittleEndian.Uint32(block[:4])
+	for len(src) > 0 {
+		binary.LittleEndian.PutUint32(block[:4], n)
+		enc.Encrypt(ks[:], block[:])
+		m := len(src)
+		if m > len(ks) {
+			m = len(ks)
+		}
+		for i := 0; i < m; i++ {
+			dst[i] = src[i] ^ ks[i]
+		}
+		dst, src = dst[m:], src[m:]
+		n++
+	}
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,90 @@
+package gcmsiv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from RFC 8452 appendix C.
+var golden = []struct {
+	key, nonce, plaintext, data, result string
+}{
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"",
+		"",
+		"dc20e2d83f25705bb49e439eca56de25",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000000000000000000003000000000000000000000000000000",
+		"01",
+		"620048ef3c1e73e57e02bb8562c416a319e73e4caac8e96a1ecb2933145a1d71e6af6a7f87287da059a71684ed3498e1",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"",
+		"",
+		"07f5f4169bbf55a8400cd47ea6fd400f",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000",
+		"",
+		"c2ef328e5c71c83b843122130f7364b761e0b97427e3df28",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0300000000000000000000000000000004000000",
+		"010000000000000000000000000000000200",
+		"43dd0163cdb48f9fe3212bf61b201976067f342bb879ad976d8242acc188ab59cabfe307",
+	},
+}
+
+func decode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestGolden(t *testing.T) {
+	for i, v := range golden {
+		a, err := New(decode(v.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce, plaintext, data := decode(v.nonce), decode(v.plaintext), decode(v.data)
+		ct := a.Seal(nil, nonce, plaintext, data)
+		if hex.EncodeToString(ct) != v.result {
+			t.Errorf("%d: Seal():\nexpected %s\ngot      %x", i, v.result, ct)
+		}
+		pt, err := a.Open(nil, nonce, ct, data)
+		if err != nil {
+			t.Errorf("%d: Open(): %v", i, err)
+		} else if !bytes.Equal(pt, plaintext) {
+			t.Errorf("%d: Open():\nexpected %x\ngot      %x", i, plaintext, pt)
+		}
+		ct[0] ^= 1
+		if _, err := a.Open(nil, nonce, ct, data); err == nil {
+			t.Errorf("%d: Open() accepted a modified ciphertext", i)
+		}
+	}
+}
+
+func BenchmarkSeal1K(b *testing.B) {
+	a, _ := New(make([]byte, 32))
+	nonce := make([]byte, NonceSize)
+	buf := make([]byte, 1024)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		a.Seal(buf[:0], nonce, buf, nil)
+	}
+}
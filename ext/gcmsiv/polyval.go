@@ -0,0 +1,117 @@
+package gcmsiv
+
+import "encoding/binary"
+
+/*
+POLYVAL is computed through GHASH, following RFC 8452 appendix A:
+
+	POLYVAL(H, X_1, ..., X_n) =
+	    ByteReverse(GHASH(mulX_GHASH(ByteReverse(H)),
+	                      ByteReverse(X_1), ..., ByteReverse(X_n)))
+
+The GHASH below is the table-driven one from Go's crypto/cipher.
+*/
+
+// fieldElement is an element of GF(2^128) in GHASH's bit order.
+type fieldElement struct {
+	low, high uint64
+}
+
+type polyval struct {
+	table [16]fieldElement
+	y     fieldElement
+}
+
+func newPolyval(key [16]byte) *polyval {
+	p := new(polyval)
+	reverse(key[:])
+	x := double(fieldElement{
+		binary.BigEndian.Uint64(key[:8]),
+		binary.BigEndian.Uint64(key[8:]),
+	})
+	p.table[reverseBits(1)] = x
+	for i := 2; i < 16; i += 2 {
+		p.table[reverseBits(i)] = double(p.table[reverseBits(i/2)])
+		p.table[reverseBits(i+1)] = add(p.table[reverseBits(i)], x)
+	}
+	return p
+}
+
+// update absorbs data, zero padding the final partial block.
+func (p *polyval) update(data []byte) {
+	var block [16]byte
+	for len(data) > 0 {
+		n := copy(block[:], data)
+		for i := n; i < len(block); i++ {
+			block[i] = 0
+		}
+		data = data[n:]
+		reverse(block[:])
+		p.y.low ^= binary.BigEndian.Uint64(block[:8])
+		p.y.high ^= binary.BigEndian.Uint64(block[8:])
+		p.mul()
+	}
+}
+
+func (p *polyval) sum() (out [16]byte) {
+	binary.BigEndian.PutUint64(out[:8], p.y.low)
+	binary.BigEndian.PutUint64(out[8:], p.y.high)
+	reverse(out[:])
+	return out
+}
+
+var reductionTable = []uint16{
+	0x0000, 0x1c20, 0x3840, 0x2460, 0x7080, 0x6ca0, 0x48c0, 0x54e0,
+	0xe100, 0xfd20, 0xd940, 0xc560, 0x9180, 0x8da0, 0xa9c0, 0xb5e0,
+}
+
+// mul sets y to y*H.
+func (p *polyval) mul() {
+	var z fieldElement
+	for i := 0; i < 2; i++ {
+		word := p.y.high
+		if i == 1 {
+			word = p.y.low
+		}
+		for j := 0; j < 64; j += 4 {
+			msw := z.high & 0xf
+			z.high >>= 4
+			z.high |= z.low << 60
+			z.low >>= 4
+			z.low ^= uint64(reductionTable[msw]) << 48
+			t := &p.table[word&0xf]
+			z.low ^= t.low
+			z.high ^= t.high
+			word >>= 4
+		}
+	}
+	p.y = z
+}
+
+func add(x, y fieldElement) fieldElement {
+	return fieldElement{x.low ^ y.low, x.high ^ y.high}
+}
+
+// double returns x multiplied by the field's generator.
+func double(x fieldElement) (d fieldElement) {
+	msbSet := x.high&1 == 1
+	d.high = x.high >> 1
+	d.high |= x.low << 63
+	d.low = x.low >> 1
+	if msbSet {
+		d.low ^= 0xe100000000000000
+	}
+	return d
+}
+
+func reverseBits(i int) int {
+	i = ((i << 2) & 0xc) | ((i >> 2) & 0x3)
+	i = ((i << 1) & 0xa) | ((i >> 1) & 0x5)
+	return i
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
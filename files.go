@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// the key location for commands that work on files rather than being run by
+// git: -key, or else the one `init' recorded in grypt.key
+func commandKeyfile() (string, error) {
+	loc := *keyFlag
+	if loc == "" {
+		out, err := git("config", "grypt.key")
+		if err != nil {
+			return "", fmt.Errorf("no key given; use -key KEYFILE, or run `grypt init' in this repository")
+		}
+		loc = strings.TrimSpace(string(out))
+	}
+	return resolveKeyfile(loc)
+}
+
+// encrypt file 'in' into file 'out'
+func encryptPath(in, out string) error {
+	if in == "" || out == "" {
+		return fmt.Errorf("usage: grypt encrypt IN OUT")
+	}
+	f, err := commandKeyfile()
+	if err != nil {
+		return err
+	}
+	k, err := ReadKey(f)
+	if err != nil {
+		return fmt.Errorf("error reading key: %v", err)
+	}
+	if k.Version == grypt.KeyX25519 {
+		// the recipients are listed at the top of the work tree
+		if in, err = absPath(in); err != nil {
+			return err
+		}
+		if out, err = absPath(out); err != nil {
+			return err
+		}
+		if err = chdirTop(); err != nil {
+			return err
+		}
+	}
+	return convertFile(in, out, func(i io.Reader, o io.Writer) error {
+		return cleanFile("", k, i, o)
+	})
+}
+
+// decrypt file 'in' into file 'out'. Unlike smudge, this fails on data that
+// is not encrypted.
+func decryptPath(in, out string) error {
+	if in == "" || out == "" {
+		return fmt.Errorf("usage: grypt decrypt IN OUT")
+	}
+	f, err := commandKeyfile()
+	if err != nil {
+		return err
+	}
+	ring, err := ReadKeyRing(f)
+	if err != nil {
+		return fmt.Errorf("error reading key: %v", err)
+	}
+	return convertFile(in, out, func(i io.Reader, o io.Writer) error {
+		return grypt.DecryptRing(i, o, ring)
+	})
+}
+
+// print the plaintext of the blob 'spec', as in `git cat-file blob', so a
+// file can be read from any commit without checking it out
+func catBlob(spec string) error {
+	if spec == "" {
+		return fmt.Errorf("usage: grypt cat REV:PATH")
+	}
+	f, err := commandKeyfile()
+	if err != nil {
+		return err
+	}
+	ring, err := ReadKeyRing(f)
+	if err != nil {
+		return fmt.Errorf("error reading key: %v", err)
+	}
+	out, err := git("cat-file", "-t", spec)
+	if err != nil {
+		return err
+	}
+	if t := strings.TrimSpace(string(out)); t != "blob" {
+		return fmt.Errorf("%s is a %s, not a file", spec, t)
+	}
+	blob, err := gitPipe("cat-file", "blob", spec)
+	if err != nil {
+		return err
+	}
+	err = smudgeFile(spec, ring, blob, os.Stdout)
+	if cerr := blob.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("`git cat-file' failed: %v", cerr)
+	}
+	return err
+}
+
+// Run fn from file 'in' to file 'out', either of which may be - for stdin
+// or stdout. A file 'out' is only replaced once fn has succeeded, and is
+// readable only by us.
+func convertFile(in, out string, fn func(io.Reader, io.Writer) error) error {
+	var i io.Reader = os.Stdin
+	if in != "-" {
+		file, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		i = file
+	}
+	if out == "-" {
+		return fn(i, os.Stdout)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(out), ".grypt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = fn(i, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), out)
+}
+
+// make a file argument absolute, leaving - alone
+func absPath(p string) (string, error) {
+	if p == "-" {
+		return p, nil
+	}
+	return filepath.Abs(p)
+}
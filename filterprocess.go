@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"polydawn.net/grypt/grypt"
+	"polydawn.net/grypt/internal/stream"
+)
+
+// filterServer speaks git's long-running filter protocol, described in
+// gitattributes(5), so one grypt handles every file of a checkout or add.
+// Smudges git lets us delay are decrypted in the background, on as many
+// goroutines as there are CPUs.
+type filterServer struct {
+	r    *bufio.Reader
+	w    *bufio.Writer
+	ring grypt.KeyRing
+
+	// delayed smudges by path, and the paths of those finished but not yet
+	// listed to git
+	delayed  map[string]*delayedBlob
+	pending  int
+	finished chan string
+	workers  chan bool
+}
+
+type delayedBlob struct {
+	out bytes.Buffer
+	err error
+}
+
+func filterProcess(i io.Reader, o io.Writer) error {
+	ring, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return fmt.Errorf("error reading key: %v", err)
+	}
+	s := &filterServer{
+		r:        bufio.NewReader(i),
+		w:        bufio.NewWriter(o),
+		ring:     ring,
+		delayed:  make(map[string]*delayedBlob),
+		finished: make(chan string),
+		workers:  make(chan bool, runtime.NumCPU()),
+	}
+	if err = s.handshake(); err != nil {
+		return err
+	}
+	for {
+		req, err := readPktList(s.r)
+		if err == io.EOF && len(req) == 0 {
+			// git is done with us
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = s.handle(req); err != nil {
+			return err
+		}
+		if err = s.w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *filterServer) handshake() error {
+	hello, err := readPktList(s.r)
+	if err != nil {
+		return err
+	}
+	if len(hello) == 0 || hello[0] != "git-filter-client" || !contains(hello[1:], "version=2") {
+		return fmt.Errorf("unsupported filter protocol: %q", hello)
+	}
+	if err = writePktList(s.w, "git-filter-server", "version=2"); err != nil {
+		return err
+	}
+	if err = s.w.Flush(); err != nil {
+		return err
+	}
+	offered, err := readPktList(s.r)
+	if err != nil {
+		return err
+	}
+	var caps []string
+	for _, c := range []string{"capability=clean", "capability=smudge", "capability=delay"} {
+		if contains(offered, c) {
+			caps = append(caps, c)
+		}
+	}
+	if err = writePktList(s.w, caps...); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// answer one request, whose key=value lines are in 'req'
+func (s *filterServer) handle(req []string) error {
+	args := make(map[string]string, len(req))
+	for _, l := range req {
+		if kv := strings.SplitN(l, "=", 2); len(kv) == 2 {
+			args[kv[0]] = kv[1]
+		}
+	}
+	if args["command"] == "list_available_blobs" {
+		return s.listAvailable()
+	}
+
+	// git sends all of the content before it reads our answer, so take it
+	// in before writing anything back
+	path := args["pathname"]
+	content, done, err := stream.Spool(&pktReader{r: s.r}, ioutil.Discard, spoolMemory)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	switch args["command"] {
+	case "clean":
+		if len(s.ring) == 0 {
+			return s.respond(path, nil, fmt.Errorf("no key"))
+		}
+		return s.respond(path, func(o io.Writer) error {
+			return cleanFile(path, s.ring[0], content, o)
+		}, nil)
+	case "smudge":
+		if b, ok := s.delayed[path]; ok {
+			// git asks again for a smudge it let us delay
+			delete(s.delayed, path)
+			return s.respond(path, func(o io.Writer) error {
+				_, err := b.out.WriteTo(o)
+				return err
+			}, b.err)
+		}
+		if buf, ok := content.(*bytes.Buffer); ok && args["can-delay"] == "1" {
+			s.delay(path, buf)
+			return writePktList(s.w, "status=delayed")
+		}
+		return s.respond(path, func(o io.Writer) error {
+			return smudgeFile(path, s.ring, content, o)
+		}, nil)
+	}
+	return writePktList(s.w, "status=error")
+}
+
+// Content up to this size is held in memory, and may be delayed
+const spoolMemory = 1024 * 1024
+
+// start decrypting 'path' in the background
+func (s *filterServer) delay(path string, content *bytes.Buffer) {
+	b := new(delayedBlob)
+	s.delayed[path] = b
+	s.pending++
+	go func() {
+		s.workers <- true
+		b.err = smudgeFile(path, s.ring, content, &b.out)
+		<-s.workers
+		s.finished <- path
+	}()
+}
+
+// tell git which delayed smudges are ready, waiting for at least one. An
+// empty list means there are no more.
+func (s *filterServer) listAvailable() error {
+	var paths []string
+	if s.pending > 0 {
+		paths = append(paths, <-s.finished)
+	more:
+		for {
+			select {
+			case p := <-s.finished:
+				paths = append(paths, p)
+			default:
+				break more
+			}
+		}
+	}
+	s.pending -= len(paths)
+	lines := make([]string, len(paths))
+	for n, p := range paths {
+		lines[n] = "pathname=" + p
+	}
+	if err := writePktList(s.w, lines...); err != nil {
+		return err
+	}
+	return writePktList(s.w, "status=success")
+}
+
+// Send the output of 'filter' as the content for 'path'. The status is only
+// sent once filter produces output or finishes, so a file that fails
+// outright is reported as an error without any content. 'err' fails the
+// request without running filter.
+func (s *filterServer) respond(path string, filter func(io.Writer) error, err error) error {
+	o := &lazyStatus{w: s.w}
+	if err == nil {
+		err = filter(o)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grypt: %s: %v\n", path, err)
+	}
+	if o.wroteStatus {
+		if werr := writeFlush(s.w); werr != nil {
+			return werr
+		}
+		if err != nil {
+			return writePktList(s.w, "status=error")
+		}
+		// keep the status sent before the content
+		return writeFlush(s.w)
+	}
+	if err != nil {
+		return writePktList(s.w, "status=error")
+	}
+	if werr := writePktList(s.w, "status=success"); werr != nil {
+		return werr
+	}
+	if werr := writeFlush(s.w); werr != nil {
+		return werr
+	}
+	return writeFlush(s.w)
+}
+
+// lazyStatus writes a success status ahead of the first content
+type lazyStatus struct {
+	w           io.Writer
+	wroteStatus bool
+}
+
+func (l *lazyStatus) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if !l.wroteStatus {
+		if err := writePktList(l.w, "status=success"); err != nil {
+			return 0, err
+		}
+		l.wroteStatus = true
+	}
+	return pktWriter{l.w}.Write(b)
+}
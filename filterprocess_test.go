@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// filterClient plays git's side of the long-running filter protocol
+type filterClient struct {
+	t *testing.T
+	w io.Writer
+	r io.Reader
+}
+
+func (c filterClient) send(content []byte, req ...string) {
+	if err := writePktList(c.w, req...); err != nil {
+		c.t.Fatal(err)
+	}
+	if content != nil {
+		pktWriter{c.w}.Write(content)
+		if err := writeFlush(c.w); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c filterClient) list() []string {
+	l, err := readPktList(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return l
+}
+
+// read a response with content, returning the final status and content
+func (c filterClient) content() (string, []byte) {
+	status := c.list()
+	if len(status) != 1 || status[0] != "status=success" {
+		return status[0], nil
+	}
+	out, err := ioutil.ReadAll(&pktReader{r: c.r})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if final := c.list(); len(final) != 0 {
+		return final[0], out
+	}
+	return status[0], out
+}
+
+func TestFilterProcess(t *testing.T) {
+	f, done := tempKeyfile(t)
+	defer done()
+	if err := WriteKey(f, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { keyfile = old }(keyfile)
+	keyfile = f
+
+	cr, cw := io.Pipe()
+	sr, sw := io.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		result <- filterProcess(cr, sw)
+		sw.Close()
+	}()
+	c := filterClient{t, cw, sr}
+
+	c.send(nil, "git-filter-client", "version=2")
+	if l := c.list(); !reflect.DeepEqual(l, []string{"git-filter-server", "version=2"}) {
+		t.Fatalf("handshake: %q", l)
+	}
+	c.send(nil, "capability=clean", "capability=smudge", "capability=delay", "capability=other")
+	if l := c.list(); !reflect.DeepEqual(l, []string{"capability=clean", "capability=smudge", "capability=delay"}) {
+		t.Fatalf("capabilities: %q", l)
+	}
+
+	big := mkRand(spoolMemory + 3*maxPktPayload)
+	for _, pt := range [][]byte{plaintext, big, {}} {
+		c.send(pt, "command=clean", "pathname=a")
+		status, ct := c.content()
+		if status != "status=success" {
+			t.Fatalf("clean: %s", status)
+		}
+		if !grypt.IsEncrypted(bufio.NewReader(bytes.NewReader(ct))) {
+			t.Fatal("clean did not encrypt")
+		}
+		c.send(ct, "command=smudge", "pathname=a")
+		status, x := c.content()
+		if status != "status=success" || !bytes.Equal(x, pt) {
+			t.Fatalf("smudge: %s, round trip failed", status)
+		}
+	}
+
+	// delayed smudges come back once they are listed
+	ct := new(bytes.Buffer)
+	if err := grypt.Encrypt(bytes.NewReader(plaintext), ct, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"b", "c"} {
+		c.send(ct.Bytes(), "command=smudge", "pathname="+p, "can-delay=1")
+		if l := c.list(); !reflect.DeepEqual(l, []string{"status=delayed"}) {
+			t.Fatalf("delay: %q", l)
+		}
+	}
+	var listed []string
+	for len(listed) < 2 {
+		c.send(nil, "command=list_available_blobs")
+		l := c.list()
+		if s := c.list(); !reflect.DeepEqual(s, []string{"status=success"}) {
+			t.Fatalf("list_available_blobs: %q", s)
+		}
+		if len(l) == 0 {
+			t.Fatal("delayed smudges were never listed")
+		}
+		listed = append(listed, l...)
+	}
+	for _, l := range listed {
+		c.send([]byte{}, "command=smudge", l)
+		status, x := c.content()
+		if status != "status=success" || !bytes.Equal(x, plaintext) {
+			t.Fatalf("delayed %s: %s, round trip failed", l, status)
+		}
+	}
+	c.send(nil, "command=list_available_blobs")
+	if l := c.list(); len(l) != 0 {
+		t.Fatalf("nothing left to list, got %q", l)
+	}
+	c.list()
+
+	// a file that does not decrypt fails alone
+	bad := append([]byte(nil), ct.Bytes()...)
+	bad[len(bad)-1] ^= 1
+	c.send(bad, "command=smudge", "pathname=d")
+	if status, _ := c.content(); status != "status=error" {
+		t.Errorf("tampered smudge: %s", status)
+	}
+
+	cw.Close()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+}
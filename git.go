@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type (
+	// An entry of `git ls-tree` or `git ls-files -s`
+	treeEntry struct {
+		Path string
+		Mode string
+		Blob string
+	}
+	// A running `git cat-file --batch`
+	catFile struct {
+		cmd *exec.Cmd
+		in  io.WriteCloser
+		out *bufio.Reader
+	}
+)
+
+// run git and return its standard output
+func git(args ...string) ([]byte, error) {
+	c := exec.Command("git", args...)
+	stderr := new(bytes.Buffer)
+	c.Stderr = stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("`git %s' failed: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return out, nil
+}
+
+// run git and stream its standard output. Closing the stream stops git.
+func gitPipe(args ...string) (io.ReadCloser, error) {
+	c := exec.Command("git", args...)
+	out, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return pipe{out, c}, nil
+}
+
+type pipe struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (p pipe) Close() error {
+	p.ReadCloser.Close()
+	return p.cmd.Wait()
+}
+
+// change to the top of the work tree, which is where git runs filters and
+// what paths from git are relative to
+func chdirTop() error {
+	out, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	return os.Chdir(strings.TrimRight(string(out), "\n"))
+}
+
+// fail if tracked files have changes that are not committed
+func requireClean(action string) error {
+	out, err := git("status", "-uno", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if len(out) != 0 {
+		return fmt.Errorf("working directory not clean, stash or merge changes before running `%s'", action)
+	}
+	return nil
+}
+
+// split NUL terminated output, as produced by git's -z options
+func splitZ(b []byte) []string {
+	s := strings.Split(string(b), "\x00")
+	return s[:len(s)-1]
+}
+
+// list the blobs in tree-ish rev, other than symlinks, which git never
+// filters
+func lsTree(rev string) ([]treeEntry, error) {
+	out, err := git("ls-tree", "-r", "-z", "--full-tree", rev)
+	if err != nil {
+		return nil, err
+	}
+	var entries []treeEntry
+	for _, line := range splitZ(out) {
+		// <mode> SP <type> SP <object> TAB <file>
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("unexpected ls-tree output: %q", line)
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected ls-tree output: %q", line)
+		}
+		if fields[1] == "blob" && fields[0] != "120000" {
+			entries = append(entries, treeEntry{line[tab+1:], fields[0], fields[2]})
+		}
+	}
+	return entries, nil
+}
+
+// list the blobs in the index. Of a conflicted path, only the first stage
+// is listed. Submodules and symlinks, which git never filters, are not.
+func lsFiles() ([]treeEntry, error) {
+	out, err := git("ls-files", "-s", "-z")
+	if err != nil {
+		return nil, err
+	}
+	var entries []treeEntry
+	seen := make(map[string]bool)
+	for _, line := range splitZ(out) {
+		// <mode> SP <object> SP <stage> TAB <file>
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("unexpected ls-files output: %q", line)
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected ls-files output: %q", line)
+		}
+		if path := line[tab+1:]; !seen[path] && fields[0] != "160000" && fields[0] != "120000" {
+			seen[path] = true
+			entries = append(entries, treeEntry{path, fields[0], fields[1]})
+		}
+	}
+	return entries, nil
+}
+
+// filter paths down to the ones .gitattributes assigns filter=grypt
+func grypted(paths []string) ([]string, error) {
+	in := new(bytes.Buffer)
+	for _, p := range paths {
+		in.WriteString(p)
+		in.WriteByte(0)
+	}
+	c := exec.Command("git", "check-attr", "-z", "--stdin", "filter")
+	c.Stdin = in
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("`git check-attr' failed: %v", err)
+	}
+	// <path> NUL <attribute> NUL <info> NUL
+	var matched []string
+	fields := splitZ(out)
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == "grypt" {
+			matched = append(matched, fields[i])
+		}
+	}
+	return matched, nil
+}
+
+func newCatFile() (*catFile, error) {
+	c := &catFile{cmd: exec.Command("git", "cat-file", "--batch")}
+	var err error
+	if c.in, err = c.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	out, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.out = bufio.NewReader(out)
+	return c, c.cmd.Start()
+}
+
+// call fn with the contents of object obj. Whatever fn leaves unread is
+// discarded.
+func (c *catFile) Read(obj string, fn func(io.Reader) error) error {
+	if _, err := fmt.Fprintln(c.in, obj); err != nil {
+		return err
+	}
+	// <object> SP <type> SP <size> LF <contents> LF
+	line, err := c.out.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return fmt.Errorf("cat-file: %s", strings.TrimSpace(line))
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return err
+	}
+	body := io.LimitReader(c.out, size)
+	err = fn(body)
+	if _, cerr := io.Copy(ioutil.Discard, body); err == nil {
+		err = cerr
+	}
+	if _, cerr := c.out.Discard(1); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *catFile) Close() error {
+	c.in.Close()
+	return c.cmd.Wait()
+}
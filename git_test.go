@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// Run as grypt when a test execs this binary, so git can use it as the
+// filter and diff driver.
+func TestMain(m *testing.M) {
+	if os.Getenv("GRYPT_TEST_MAIN") == "1" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// testRepo is a scratch git repository using this binary as grypt
+type testRepo struct {
+	t   *testing.T
+	dir string
+	key string
+}
+
+func newTestRepo(t *testing.T) (*testRepo, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "grypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &testRepo{t, filepath.Join(dir, "repo"), filepath.Join(dir, "key")}
+	if err = os.Mkdir(r.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.git("init", "-q", ".")
+	r.git("config", "user.name", "grypt")
+	r.git("config", "user.email", "grypt@example.com")
+	r.grypt("keygen", r.key)
+	return r, func() { os.RemoveAll(dir) }
+}
+
+func (r *testRepo) cmd(name string, args ...string) *exec.Cmd {
+	c := exec.Command(name, args...)
+	c.Dir = r.dir
+	c.Env = append(os.Environ(), "GRYPT_TEST_MAIN=1", "GIT_CONFIG_NOSYSTEM=1", "HOME="+r.dir, "GIT_PAGER=cat")
+	return c
+}
+
+func (r *testRepo) run(name string, args ...string) string {
+	out, err := r.cmd(name, args...).CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("%s %s: %v\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func (r *testRepo) git(args ...string) string {
+	return r.run("git", args...)
+}
+
+func (r *testRepo) grypt(args ...string) string {
+	return r.run(os.Args[0], args...)
+}
+
+func (r *testRepo) write(path, content string) {
+	if err := ioutil.WriteFile(filepath.Join(r.dir, path), []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func TestTextconv(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("-cachetextconv", "init", r.key)
+	r.write(".gitattributes", "secret filter=grypt diff=grypt\n")
+	r.write("secret", "swordfish\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	if blob := r.git("cat-file", "blob", "HEAD:secret"); !strings.HasPrefix(blob, grypt.Magic) {
+		t.Fatalf("secret was committed unencrypted: %q", blob)
+	}
+
+	r.write("secret", "hunter2\n")
+	out := r.git("diff")
+	if !strings.Contains(out, "-swordfish") || !strings.Contains(out, "+hunter2") {
+		t.Errorf("git diff does not show the plaintext:\n%s", out)
+	}
+	r.git("commit", "-q", "-a", "-m", "two")
+	for n := 0; n < 2; n++ {
+		out = r.git("log", "-p")
+		if !strings.Contains(out, "-swordfish") || !strings.Contains(out, "+hunter2") || strings.Contains(out, "Binary") {
+			t.Errorf("git log -p does not show the plaintext:\n%s", out)
+		}
+	}
+	if r.git("for-each-ref", "refs/notes/textconv/grypt") == "" {
+		t.Error("diff.grypt.cachetextconv did not cache the textconv output")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("init", r.key)
+	r.write(".gitattributes", "secret filter=grypt merge=grypt\n")
+	r.write("secret", "1\n2\n3\n4\n5\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "base")
+	r.git("branch", "side")
+
+	r.write("secret", "1\n2\n3\n4\nfive\n")
+	r.git("commit", "-q", "-a", "-m", "ours")
+	r.git("checkout", "-q", "side")
+	r.write("secret", "one\n2\n3\n4\n5\n")
+	r.git("commit", "-q", "-a", "-m", "theirs")
+	r.git("checkout", "-q", "-")
+	r.git("merge", "-q", "--no-edit", "side")
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, "secret")); string(got) != "one\n2\n3\n4\nfive\n" {
+		t.Errorf("merged to %q", got)
+	}
+	if blob := r.git("cat-file", "blob", "HEAD:secret"); !strings.HasPrefix(blob, grypt.Magic) {
+		t.Fatal("merge result was committed unencrypted")
+	}
+
+	r.write("secret", "one\n2\nthree\n4\nfive\n")
+	r.git("commit", "-q", "-a", "-m", "ours again")
+	r.git("checkout", "-q", "side")
+	r.write("secret", "one\n2\nTHREE\n4\n5\n")
+	r.git("commit", "-q", "-a", "-m", "theirs again")
+	r.git("checkout", "-q", "-")
+	if r.cmd("git", "merge", "-q", "--no-edit", "side").Run() == nil {
+		t.Fatal("conflicting merge succeeded")
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(r.dir, "secret"))
+	for _, want := range []string{"<<<<<<< ours\nthree\n=======\nTHREE\n>>>>>>> theirs\n", "five\n"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("conflicted file lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestTrack(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("init", r.key)
+	r.write("a.secret", "swordfish\n")
+	r.write("b.txt", "hello\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "plain")
+
+	r.grypt("track", "*.secret")
+	if blob := r.git("cat-file", "blob", ":a.secret"); !strings.HasPrefix(blob, grypt.Magic) {
+		t.Fatal("track did not encrypt the staged file")
+	}
+	if blob := r.git("cat-file", "blob", ":b.txt"); blob != "hello\n" {
+		t.Fatalf("track encrypted a file it does not match: %q", blob)
+	}
+	r.git("commit", "-q", "-m", "track")
+	attrs := r.git("show", "HEAD:.gitattributes")
+	if attrs != "*.secret filter=grypt diff=grypt merge=grypt\n" {
+		t.Fatalf("unexpected .gitattributes: %q", attrs)
+	}
+
+	r.grypt("track", "*.secret")
+	if r.git("show", ":.gitattributes") != attrs {
+		t.Error("tracking a pattern twice changed .gitattributes")
+	}
+	if r.cmd(os.Args[0], "track", "*.nothing").Run() == nil {
+		t.Error("tracked a pattern that matches no files")
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, ".gitattributes")); string(got) != attrs {
+		t.Errorf("a failed track changed .gitattributes to %q", got)
+	}
+
+	r.grypt("untrack", "*.secret")
+	if blob := r.git("cat-file", "blob", ":a.secret"); blob != "swordfish\n" {
+		t.Errorf("untrack left the staged file encrypted: %q", blob)
+	}
+	if attrs := r.git("show", ":.gitattributes"); attrs != "" {
+		t.Errorf("untrack left %q", attrs)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("init", r.key)
+	r.write(".gitattributes", "*.secret filter=grypt\n")
+	r.write("a.secret", "one\n")
+	r.write("b.secret", "two\n")
+	r.write("c.txt", "three\n")
+	// git does not filter symlinks, so there is nothing to report
+	if err := os.Symlink("a.secret", filepath.Join(r.dir, "link.secret")); err != nil {
+		t.Fatal(err)
+	}
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	r.write("b.secret", "changed\n")
+
+	var report []pathStatus
+	if err := json.Unmarshal([]byte(r.grypt("-json", "status")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 2 || report[0].Path != "a.secret" || report[1].Path != "b.secret" {
+		t.Fatalf("unexpected paths: %+v", report)
+	}
+	k, err := ReadKey(r.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range report {
+		c := s.Committed
+		if c == nil || !c.Encrypted || c.Scheme != k.Scheme.String() || c.KeyID != hex.EncodeToString(k.ID()) {
+			t.Errorf("%s: committed blob reported as %+v", s.Path, c)
+		}
+	}
+	if report[0].WorkTree != "unchanged" || report[1].WorkTree != "modified" {
+		t.Errorf("work tree reported as %s and %s", report[0].WorkTree, report[1].WorkTree)
+	}
+}
+
+func TestPreCommitHook(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("init", r.key)
+	hooks := filepath.Join(r.dir, ".git", "hooks")
+	os.MkdirAll(hooks, 0755)
+	if err := ioutil.WriteFile(filepath.Join(hooks, "pre-commit"), []byte("#!/bin/sh\ntouch chained\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.grypt("install-hooks")
+	r.grypt("install-hooks")
+
+	r.write(".gitattributes", "*.secret filter=grypt\n")
+	r.write("a.secret", "one\n")
+	// a symlink is never encrypted, and must not be refused for it
+	if err := os.Symlink("a.secret", filepath.Join(r.dir, "link.secret")); err != nil {
+		t.Fatal(err)
+	}
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	if _, err := os.Stat(filepath.Join(r.dir, "chained")); err != nil {
+		t.Error("the existing hook did not run")
+	}
+
+	// a clone without the filter config stages plaintext
+	r.git("config", "--remove-section", "filter.grypt")
+	r.write("b.secret", "two\n")
+	r.git("add", "b.secret")
+	c := r.cmd("git", "commit", "-q", "-m", "two")
+	out, err := c.CombinedOutput()
+	if err == nil {
+		t.Fatal("committed a plaintext secret")
+	}
+	if !strings.Contains(string(out), "b.secret") || strings.Contains(string(out), "a.secret") {
+		t.Errorf("hook output does not name just b.secret:\n%s", out)
+	}
+}
+
+func TestUninit(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("-cachetextconv", "init", r.key)
+	r.write(".gitattributes", "*.secret filter=grypt diff=grypt\n")
+	r.write("a.secret", "one\n")
+	r.write("b.secret", "two\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	r.write("a.secret", "uno\n")
+	r.git("commit", "-q", "-a", "-m", "two")
+	r.git("log", "-p")
+	r.write("b.secret", "uncommitted\n")
+
+	out := r.grypt("-checkout", "uninit")
+	if !strings.Contains(out, "b.secret") {
+		t.Errorf("no warning about the uncommitted change:\n%s", out)
+	}
+	if r.cmd("git", "config", "--get-regexp", "grypt").Run() == nil {
+		t.Error("grypt config is left")
+	}
+	if r.git("for-each-ref", "refs/notes/textconv/grypt") != "" {
+		t.Error("cached textconv output is left")
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, "a.secret")); !strings.HasPrefix(string(got), grypt.Magic) {
+		t.Errorf("a.secret was not checked out as ciphertext: %q", got)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, "b.secret")); string(got) != "uncommitted\n" {
+		t.Errorf("the uncommitted change was lost: %q", got)
+	}
+	if st := r.git("status", "--porcelain"); st != " M b.secret\n" {
+		t.Errorf("unexpected status after uninit:\n%s", st)
+	}
+}
+
+func TestPortableConfig(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("keygen", DefaultKeyfile)
+	r.grypt("init")
+	cfg := r.git("config", "filter.grypt.clean")
+	if !strings.Contains(cfg, "'"+DefaultKeyfile+"'") {
+		t.Fatalf("config does not use the repository relative key: %s", cfg)
+	}
+	r.write(".gitattributes", "secret filter=grypt\n")
+	r.write("secret", "swordfish\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+
+	// the key moves with the repository
+	moved := r.dir + "-moved"
+	if err := os.Rename(r.dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	r.dir = moved
+	os.Remove(filepath.Join(r.dir, "secret"))
+	r.git("checkout", "--", "secret")
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, "secret")); string(got) != "swordfish\n" {
+		t.Errorf("checked out %q after moving the repository", got)
+	}
+
+	// so does one given by a path inside the git directory
+	r.grypt("init", filepath.Join(r.dir, ".git", "grypt", "key"))
+	if cfg = r.git("config", "filter.grypt.smudge"); !strings.Contains(cfg, "'"+DefaultKeyfile+"'") {
+		t.Errorf("key in the git directory is not made relative: %s", cfg)
+	}
+}
+
+func TestFileCommands(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("init", r.key)
+	r.write(".gitattributes", "secret filter=grypt\n")
+	r.write("secret", "swordfish\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	r.write("secret", "hunter2\n")
+	r.git("commit", "-q", "-a", "-m", "two")
+
+	if out := r.grypt("cat", "HEAD~:secret"); out != "swordfish\n" {
+		t.Errorf("cat of the first commit gave %q", out)
+	}
+	if out := r.grypt("cat", ":secret"); out != "hunter2\n" {
+		t.Errorf("cat of the index gave %q", out)
+	}
+	if c := r.cmd(os.Args[0], "cat", "HEAD"); c.Run() == nil {
+		t.Error("cat of a commit succeeded")
+	}
+
+	r.write("plain", "correct horse\n")
+	r.grypt("encrypt", "plain", "enc")
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, "enc")); !strings.HasPrefix(string(got), grypt.Magic) {
+		t.Fatalf("encrypt wrote %q", got)
+	}
+	r.grypt("decrypt", "enc", "dec")
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, "dec")); string(got) != "correct horse\n" {
+		t.Errorf("decrypt wrote %q", got)
+	}
+	c := r.cmd(os.Args[0], "decrypt", "-", "-")
+	c.Stdin = strings.NewReader(r.git("cat-file", "blob", "HEAD:secret"))
+	if out, err := c.Output(); err != nil || string(out) != "hunter2\n" {
+		t.Errorf("decrypt from stdin to stdout gave %q, %v", out, err)
+	}
+
+	// plaintext is refused, and leaves nothing behind
+	if r.cmd(os.Args[0], "decrypt", "plain", "out").Run() == nil {
+		t.Error("decrypt of plaintext succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(r.dir, "out")); !os.IsNotExist(err) {
+		t.Errorf("a failed decrypt left its output: %v", err)
+	}
+
+	// outside the repository, the key has to be given
+	outside := filepath.Join(filepath.Dir(r.dir), "enc")
+	if err := os.Rename(filepath.Join(r.dir, "enc"), outside); err != nil {
+		t.Fatal(err)
+	}
+	c = r.cmd(os.Args[0], "decrypt", outside, "-")
+	c.Dir = filepath.Dir(r.dir)
+	if c.Run() == nil {
+		t.Error("decrypt found a key outside the repository")
+	}
+	c = r.cmd(os.Args[0], "-key", r.key, "decrypt", outside, "-")
+	c.Dir = filepath.Dir(r.dir)
+	if out, err := c.Output(); err != nil || string(out) != "correct horse\n" {
+		t.Errorf("decrypt with -key gave %q, %v", out, err)
+	}
+}
+
+// A repository whose files were committed by the first grypt, in the format
+// from before Magic, as in grypt/testdata.
+func TestLegacyRepo(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	fixture := func(name string) string {
+		bits, err := ioutil.ReadFile(filepath.Join("grypt", "testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bits)
+	}
+	r.write(".gitattributes", "secret filter=grypt\n")
+	r.write("secret", fixture("legacy-aes256sha256.grypt"))
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	legacyKey := filepath.Join(filepath.Dir(r.key), "legacy.key")
+	if err := ioutil.WriteFile(legacyKey, []byte(fixture("legacy-aes256sha256.key")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r.grypt("init", legacyKey)
+	if got, _ := ioutil.ReadFile(filepath.Join(r.dir, "secret")); string(got) != fixture("legacy.txt") {
+		t.Errorf("checkout left %q", got)
+	}
+	if out := r.git("status", "--porcelain"); out != "" {
+		t.Errorf("the decrypted file shows up as changed:\n%s", out)
+	}
+	if out, err := r.cmd(os.Args[0], "audit").Output(); err != nil || strings.Contains(string(out), "secret") {
+		t.Errorf("audit lists the file as plaintext: %v\n%s", err, out)
+	}
+
+	// without the key that wrote it, it is reported as undecryptable
+	c := r.cmd(os.Args[0], "smudge", r.key, "secret")
+	c.Stdin = strings.NewReader(fixture("legacy-aes256sha256.grypt"))
+	out, err := c.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "original grypt format") {
+		t.Errorf("smudge with another key did not refuse: %v\n%s", err, out)
+	}
+	r.grypt("uninit")
+	out, err = r.cmd(os.Args[0], "audit").CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "\tsecret\n") || strings.Contains(string(out), "--renormalize") {
+		t.Errorf("audit output for a file in the original format: %v\n%s", err, out)
+	}
+	r.git("config", "grypt.key", r.key)
+	out, err = r.cmd(os.Args[0], "audit").CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "\tsecret: ") || strings.Contains(string(out), "--renormalize") {
+		t.Errorf("audit output for a file the key does not decrypt: %v\n%s", err, out)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	r.grypt("init", r.key)
+	r.write(".gitattributes", "secret filter=grypt\nsub filter=grypt\nlink filter=grypt\n")
+	r.write("secret", "swordfish\n")
+	// a symlink the patterns match is not a file to re-encrypt: its blob is
+	// the path it points to
+	if err := os.Symlink("secret", filepath.Join(r.dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	link := r.git("ls-files", "-s", "link")
+	// nor is a submodule
+	head := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+	if err := os.Mkdir(filepath.Join(r.dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.git("update-index", "--add", "--cacheinfo", "160000,"+head+",sub")
+	r.git("commit", "-q", "-m", "two")
+	old := r.git("cat-file", "blob", ":secret")
+
+	newKey := filepath.Join(filepath.Dir(r.key), "new")
+	r.grypt("keygen", newKey)
+	r.grypt("rotate", r.key, newKey)
+	if got := r.git("ls-files", "-s", "sub"); !strings.HasPrefix(got, "160000 "+head+" 0\tsub") {
+		t.Errorf("rotate changed the submodule: %q", got)
+	}
+	if got := r.git("ls-files", "-s", "link"); got != link {
+		t.Errorf("rotate changed the symlink: %q, was %q", got, link)
+	}
+	if r.git("cat-file", "blob", ":secret") == old {
+		t.Error("rotate did not re-encrypt the file")
+	}
+	if out := r.run(os.Args[0], "-key", newKey, "cat", ":secret"); out != "swordfish\n" {
+		t.Errorf("the new key decrypts %q", out)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	alice := filepath.Join(filepath.Dir(r.key), "alice")
+	bob := filepath.Join(filepath.Dir(r.key), "bob")
+	alicePub := strings.TrimSpace(r.grypt("identity", alice))
+	bobPub := strings.TrimSpace(r.grypt("identity", bob))
+	r.grypt("init", alice)
+	r.grypt("add-user", alicePub, "alice")
+	r.write(".gitattributes", "secret filter=grypt\n")
+	r.write("secret", "swordfish\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "one")
+	cat := func(key string) (string, error) {
+		out, err := r.cmd(os.Args[0], "-key", key, "cat", "HEAD:secret").Output()
+		return string(out), err
+	}
+	if out, err := cat(bob); err == nil {
+		t.Errorf("bob decrypts the file before being added: %q", out)
+	}
+
+	r.grypt("add-user", bobPub, "bob")
+	r.git("commit", "-q", "-m", "two")
+	for _, key := range []string{alice, bob} {
+		if out, err := cat(key); err != nil || out != "swordfish\n" {
+			t.Errorf("%s after add-user: %q, %v", filepath.Base(key), out, err)
+		}
+	}
+	aliceKey, err := ReadKey(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey := func() []byte {
+		k, _, err := grypt.DataKey(strings.NewReader(r.git("cat-file", "blob", "HEAD:secret")), aliceKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.ID()
+	}
+	shared := dataKey()
+
+	r.grypt("remove-user", "bob")
+	r.git("commit", "-q", "-m", "three")
+	if bytes.Equal(dataKey(), shared) {
+		t.Error("remove-user did not re-encrypt the file under a new data key")
+	}
+	if out, err := cat(alice); err != nil || out != "swordfish\n" {
+		t.Errorf("alice after remove-user: %q, %v", out, err)
+	}
+	if out, err := cat(bob); err == nil {
+		t.Errorf("bob still decrypts the file after remove-user: %q", out)
+	}
+	if recipients := r.git("show", "HEAD:"+RecipientsFile); strings.Contains(recipients, bobPub) {
+		t.Errorf("bob is still listed:\n%s", recipients)
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	r, done := newTestRepo(t)
+	defer done()
+	// options may follow the subcommand
+	out, err := r.cmd(os.Args[0], "split", "-n", "5", "-k", "3", r.key).Output()
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	shares := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+	rebuilt := filepath.Join(filepath.Dir(r.key), "rebuilt")
+	c := r.cmd(os.Args[0], "combine", rebuilt)
+	c.Stdin = strings.NewReader(strings.Join([]string{shares[4], shares[0], shares[2]}, "\n"))
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("combine: %v\n%s", err, out)
+	}
+	if r.grypt("keys", rebuilt) != r.grypt("keys", r.key) {
+		t.Error("three shares rebuilt a different key")
+	}
+	c = r.cmd(os.Args[0], "combine", filepath.Join(filepath.Dir(r.key), "short"))
+	c.Stdin = strings.NewReader(shares[1] + "\n" + shares[3] + "\n")
+	if out, err := c.CombinedOutput(); err == nil || !strings.Contains(string(out), "3 different ones are needed") {
+		t.Errorf("two shares: %v\n%s", err, out)
+	}
+}
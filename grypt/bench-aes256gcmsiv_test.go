@@ -0,0 +1,48 @@
+package grypt
+
+import "testing"
+
+func BenchmarkAES256_GCMSIVEncrypt1K(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, AES256_GCMSIV, 1024)
+}
+func BenchmarkAES256_GCMSIVDecrypt1K(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, AES256_GCMSIV, 1024)
+}
+
+func BenchmarkAES256_GCMSIVEncrypt4K(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, AES256_GCMSIV, 4*1024)
+}
+func BenchmarkAES256_GCMSIVDecrypt4K(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, AES256_GCMSIV, 4*1024)
+}
+
+func BenchmarkAES256_GCMSIVEncrypt1M(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, AES256_GCMSIV, 1024*1024)
+}
+func BenchmarkAES256_GCMSIVDecrypt1M(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, AES256_GCMSIV, 1024*1024)
+}
+
+func BenchmarkAES256_GCMSIVEncrypt2M(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, AES256_GCMSIV, 2*(1024*1024))
+}
+func BenchmarkAES256_GCMSIVDecrypt2M(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, AES256_GCMSIV, 2*(1024*1024))
+}
+
+func BenchmarkAES256_GCMSIVEncrypt4M(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, AES256_GCMSIV, 4*(1024*1024))
+}
+func BenchmarkAES256_GCMSIVDecrypt4M(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, AES256_GCMSIV, 4*(1024*1024))
+}
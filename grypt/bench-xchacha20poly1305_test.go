@@ -0,0 +1,48 @@
+package grypt
+
+import "testing"
+
+func BenchmarkXChaCha20_Poly1305Encrypt1K(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, XChaCha20_Poly1305, 1024)
+}
+func BenchmarkXChaCha20_Poly1305Decrypt1K(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, XChaCha20_Poly1305, 1024)
+}
+
+func BenchmarkXChaCha20_Poly1305Encrypt4K(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, XChaCha20_Poly1305, 4*1024)
+}
+func BenchmarkXChaCha20_Poly1305Decrypt4K(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, XChaCha20_Poly1305, 4*1024)
+}
+
+func BenchmarkXChaCha20_Poly1305Encrypt1M(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, XChaCha20_Poly1305, 1024*1024)
+}
+func BenchmarkXChaCha20_Poly1305Decrypt1M(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, XChaCha20_Poly1305, 1024*1024)
+}
+
+func BenchmarkXChaCha20_Poly1305Encrypt2M(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, XChaCha20_Poly1305, 2*(1024*1024))
+}
+func BenchmarkXChaCha20_Poly1305Decrypt2M(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, XChaCha20_Poly1305, 2*(1024*1024))
+}
+
+func BenchmarkXChaCha20_Poly1305Encrypt4M(b *testing.B) {
+	b.ReportAllocs()
+	encBench(b, XChaCha20_Poly1305, 4*(1024*1024))
+}
+func BenchmarkXChaCha20_Poly1305Decrypt4M(b *testing.B) {
+	b.ReportAllocs()
+	decBench(b, XChaCha20_Poly1305, 4*(1024*1024))
+}
@@ -0,0 +1,562 @@
+/*
+Package grypt holds the encryption behind the grypt command, for programs
+that want to read or write its files without going through git.
+
+Keys are loaded with ParseKeyRing or ReadKeyRing. Encrypt and DecryptRing
+work on whole streams; Writer and Reader wrap the same thing for code that
+wants an io.WriteCloser or io.Reader. Failures that callers may want to
+tell apart are returned as the Err values below, and can be compared with ==.
+*/
+package grypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"polydawn.net/grypt/internal/stream"
+)
+
+/*
+The files we write/read have a small header tacked on (see type Header and
+key.go) that carries some encryption scheme information and relevant nonces.
+It is preceded by Magic and a single format version byte, so data that was
+never encrypted by grypt can be told apart from a damaged file. Version 1
+files are encrypted with a shared Key; version 2 headers also carry the
+file's data key sealed to each recipient (see recipients.go). Files from
+before Magic are still decrypted; see legacy.go.
+
+After the header the ciphertext is cut into chunks of Header.ChunkSize bytes,
+each followed by its own MAC, so neither direction has to hold the whole file
+in memory. Every chunk but the last is exactly ChunkSize long; the last one is
+shorter (possibly empty), and its MAC says so.
+
+CTR schemes run one keystream over the whole file and follow each chunk with
+an HMAC. AEAD schemes seal each chunk separately, with a nonce made from the
+IV, the chunk's position and the final flag; the AEAD tag is the chunk's MAC.
+
+The header format should probably stop changing once We are happy with how the
+encryption works.
+*/
+
+const (
+	// Every file starts with this, followed by the format version.
+	Magic = "\x00GRYPT"
+	// Version of the file format written by Encrypt.
+	FormatVersion = 1
+	// Version of the file format written by EncryptTo.
+	FormatVersionRecipients = 2
+	// Largest header Decrypt will read.
+	maxHeaderSize = 64 * 1024
+
+	// Size of the plaintext chunks new files are cut into.
+	ChunkSize = 64 * 1024
+	// Largest chunk size Decrypt will accept from a header.
+	maxChunkSize = 16 * 1024 * 1024
+	// Inputs up to this size are kept in memory while Encrypt computes the
+	// IV; larger ones are spooled to disk.
+	spoolMemory = 1024 * 1024
+)
+
+var (
+	// The data does not start with Magic; it was never encrypted by grypt.
+	ErrNotEncrypted = errors.New("data is not encrypted by grypt")
+	// The data was written by a newer or unknown grypt file format.
+	ErrUnknownVersion = errors.New("unknown grypt format version")
+	// The header is damaged.
+	ErrMalformedHeader = errors.New("malformed grypt header")
+	// The data was encrypted with a different scheme than the key's.
+	ErrWrongScheme = errors.New("key is unable to decrypt this data")
+	// The ciphertext failed its MAC, or was cut short.
+	ErrUnverified = errors.New("unable to verify file")
+	// The file was encrypted to recipients that do not include the key.
+	ErrNotRecipient = errors.New("key is not among this file's recipients")
+	// No key has the ID the file was encrypted with.
+	ErrUnknownKey = errors.New("no key matches the file's key ID")
+)
+
+type (
+	// Header contains information about the encryption scheme. On disk it
+	// follows Magic and the FormatVersion byte.
+	Header struct {
+		Scheme    Scheme
+		IV        []byte
+		ChunkSize int
+		// ID of the key that encrypted the file, if it was a shared Key
+		KeyID []byte `asn1:"optional,tag:0"`
+		// Public half of the key the data key was sealed with
+		Ephemeral []byte `asn1:"optional"`
+		// The data key, sealed to each recipient
+		Recipients []Recipient `asn1:"optional"`
+	}
+)
+
+// ReadHeader consumes the magic, format version and header from i. The
+// returned reader continues with the chunks that follow. Files in the
+// original format have no such header, and give ErrLegacy.
+func ReadHeader(i io.Reader) (Header, io.Reader, error) {
+	header := Header{}
+	b := bufio.NewReader(i)
+	if IsLegacy(b) {
+		return header, nil, ErrLegacy
+	}
+	prefix := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(b, prefix); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return header, nil, ErrNotEncrypted
+	} else if err != nil {
+		return header, nil, err
+	}
+	if string(prefix[:len(Magic)]) != Magic {
+		return header, nil, ErrNotEncrypted
+	}
+	version := prefix[len(Magic)]
+	if version != FormatVersion && version != FormatVersionRecipients {
+		return header, nil, ErrUnknownVersion
+	}
+
+	bits, err := stream.ReadDER(b, maxHeaderSize)
+	if err == stream.ErrMalformed || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return header, nil, ErrMalformedHeader
+	} else if err != nil {
+		return header, nil, err
+	}
+	if _, err := asn1.Unmarshal(bits, &header); err != nil {
+		return header, nil, ErrMalformedHeader
+	}
+	if header.ChunkSize <= 0 || header.ChunkSize > maxChunkSize {
+		return header, nil, ErrMalformedHeader
+	}
+	if _, ok := header.Scheme.Info(); !ok {
+		return header, nil, ErrInvalidScheme
+	}
+	if (version == FormatVersionRecipients) != (len(header.Recipients) != 0) {
+		return header, nil, ErrMalformedHeader
+	}
+	return header, b, nil
+}
+
+// writeHeader writes the magic, format version and header to o.
+func writeHeader(o io.Writer, header Header) error {
+	bits, err := asn1.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(o, Magic); err != nil {
+		return err
+	}
+	version := byte(FormatVersion)
+	if len(header.Recipients) != 0 {
+		version = FormatVersionRecipients
+	}
+	if _, err = o.Write([]byte{version}); err != nil {
+		return err
+	}
+	_, err = o.Write(bits)
+	return err
+}
+
+// Decrypt ciphertext into plaintext.
+//
+// Each chunk is verified before any of it is written to o. If a later chunk
+// fails to verify, the chunks before it have already been written.
+func Decrypt(i io.Reader, o io.Writer, k Key) error {
+	return DecryptRing(i, o, KeyRing{k})
+}
+
+// DecryptRing is Decrypt with whichever key in ring the data was encrypted
+// with. Unlike Reader, it also decrypts the original format, with the
+// version 1 keys in ring.
+func DecryptRing(i io.Reader, o io.Writer, ring KeyRing) error {
+	b := bufio.NewReader(i)
+	if IsLegacy(b) {
+		return decryptLegacy(b, o, ring)
+	}
+	r, err := NewReader(b, ring)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := r.next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if _, werr := o.Write(chunk); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Reader decrypts the data read from an underlying reader. Like Decrypt,
+// it only returns plaintext that has been verified, one chunk at a time.
+type Reader struct {
+	header Header
+	r      io.Reader
+	// the sealer for the first chunk is picked once it has been read
+	sealers []sealer
+	s       sealer
+	buf     []byte
+	// plaintext not yet returned by Read
+	chunk []byte
+	n     uint64
+	err   error
+}
+
+// NewReader reads the header from i and returns a Reader for the
+// plaintext, decrypted with whichever key in ring the data was encrypted
+// with.
+func NewReader(i io.Reader, ring KeyRing) (*Reader, error) {
+	header, r, err := ReadHeader(i)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := keysFor(ring, header)
+	if err != nil {
+		return nil, err
+	}
+	if len(header.IV) != header.Scheme.IVSize() {
+		return nil, ErrMalformedHeader
+	}
+	sealers := make([]sealer, len(keys))
+	for n, k := range keys {
+		if sealers[n], err = newSealer(k, header.IV); err != nil {
+			return nil, fmt.Errorf("unabled to create cipher: %v", err)
+		}
+	}
+	buf := make([]byte, header.ChunkSize+sealers[0].Overhead())
+	return &Reader{header: header, r: r, sealers: sealers, buf: buf}, nil
+}
+
+// Header returns the header of the data being decrypted.
+func (d *Reader) Header() Header {
+	return d.header
+}
+
+func (d *Reader) Read(p []byte) (int, error) {
+	for len(d.chunk) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.chunk, d.err = d.next()
+	}
+	n := copy(p, d.chunk)
+	d.chunk = d.chunk[n:]
+	return n, nil
+}
+
+// next verifies and decrypts the next chunk. It returns io.EOF along with
+// the final one.
+func (d *Reader) next() ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	macSize := d.sealers[0].Overhead()
+	m, err := io.ReadFull(d.r, d.buf)
+	final := err == io.ErrUnexpectedEOF
+	if err == io.EOF || (final && m < macSize) {
+		return nil, ErrUnverified
+	}
+	if err != nil && !final {
+		return nil, err
+	}
+	var chunk []byte
+	if d.n == 0 {
+		// files without a key ID may match several keys; the first
+		// chunk tells which one it was
+		d.s, chunk, err = openFirst(d.sealers, d.buf[:m], final)
+	} else {
+		chunk, err = d.s.Open(d.buf[:m], d.n, final)
+	}
+	if err != nil {
+		return nil, ErrUnverified
+	}
+	d.n++
+	if final {
+		d.err = io.EOF
+	}
+	return chunk, d.err
+}
+
+// keysFor picks the keys in ring that may have encrypted a file with header,
+// unwrapping the data key if the file was encrypted to recipients.
+func keysFor(ring KeyRing, header Header) ([]Key, error) {
+	if len(header.Recipients) != 0 {
+		err := ErrNotRecipient
+		for _, k := range ring {
+			if k.Version != KeyX25519 {
+				continue
+			}
+			dataKey, uerr := unwrapKey(header, k)
+			if uerr == nil {
+				return []Key{dataKey}, nil
+			}
+			if uerr != ErrNotRecipient {
+				err = uerr
+			}
+		}
+		return nil, err
+	}
+
+	var keys []Key
+	schemeMatched := false
+	for _, k := range ring {
+		if k.Version == KeyX25519 || k.Scheme != header.Scheme {
+			continue
+		}
+		schemeMatched = true
+		if header.KeyID == nil || bytes.Equal(header.KeyID, k.ID()) {
+			keys = append(keys, k)
+		}
+	}
+	if !schemeMatched {
+		return nil, ErrWrongScheme
+	}
+	if len(keys) == 0 {
+		return nil, ErrUnknownKey
+	}
+	return keys, nil
+}
+
+// openFirst opens the first chunk with each sealer in turn and returns the
+// first one that verifies it.
+func openFirst(sealers []sealer, framed []byte, final bool) (sealer, []byte, error) {
+	if len(sealers) == 1 {
+		chunk, err := sealers[0].Open(framed, 0, final)
+		return sealers[0], chunk, err
+	}
+	trial := make([]byte, len(framed))
+	for _, s := range sealers {
+		copy(trial, framed)
+		if chunk, err := s.Open(trial, 0, final); err == nil {
+			return s, chunk, nil
+		}
+	}
+	return nil, nil, ErrUnverified
+}
+
+// DecryptOrCopy is DecryptRing, except that data which is in neither format
+// is copied to o unchanged. It reports whether i was encrypted.
+func DecryptOrCopy(i io.Reader, o io.Writer, ring KeyRing) (bool, error) {
+	b := bufio.NewReader(i)
+	if !IsEncrypted(b) && !IsLegacy(b) {
+		_, err := io.Copy(o, b)
+		return false, err
+	}
+	return true, DecryptRing(b, o, ring)
+}
+
+// IsEncrypted reports whether the data in b starts with Magic, without
+// consuming any of it.
+func IsEncrypted(b *bufio.Reader) bool {
+	prefix, _ := b.Peek(len(Magic))
+	return string(prefix) == Magic
+}
+
+// Encrypt plaintext to ciphertext.
+//
+// The IV is an HMAC of the whole plaintext, so the input is read twice; see
+// stream.Spool for how it is kept around in between.
+func Encrypt(i io.Reader, o io.Writer, k Key) error {
+	return encrypt(i, o, k, Header{KeyID: k.ID()})
+}
+
+// Writer encrypts what is written to it, and writes the ciphertext to an
+// underlying writer once it is closed: the IV depends on all of the
+// plaintext, so no ciphertext can come out before then.
+type Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+	err  error
+}
+
+// NewWriter returns a Writer that encrypts to o with k.
+func NewWriter(o io.Writer, k Key) *Writer {
+	return newWriter(func(r io.Reader) error { return Encrypt(r, o, k) })
+}
+
+// NewWriterTo returns a Writer that encrypts to o with dataKey, sealed to
+// each of the recipients, as EncryptTo does.
+func NewWriterTo(o io.Writer, dataKey Key, recipients []PublicKey) *Writer {
+	return newWriter(func(r io.Reader) error { return EncryptTo(r, o, dataKey, recipients) })
+}
+
+func newWriter(encrypt func(io.Reader) error) *Writer {
+	pr, pw := io.Pipe()
+	w := &Writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := encrypt(pr)
+		if err == nil {
+			pr.CloseWithError(io.ErrClosedPipe)
+		} else {
+			pr.CloseWithError(err)
+		}
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close finishes the plaintext and writes out the ciphertext.
+func (w *Writer) Close() error {
+	if w.done != nil {
+		w.pw.Close()
+		w.err = <-w.done
+		w.done = nil
+	}
+	return w.err
+}
+
+// encrypt fills in the scheme, IV and chunk size of header, writes it and
+// then the ciphertext.
+func encrypt(i io.Reader, o io.Writer, k Key, header Header) error {
+	sk, err := k.subkeys()
+	if err != nil {
+		return err
+	}
+	hmacIV := hmac.New(k.Scheme.Hash(), sk.IV)
+
+	// Read in the file, calculating the IV and keeping it for the second pass
+	plaintext, done, err := stream.Spool(i, hmacIV, spoolMemory)
+	if err != nil {
+		return err
+	}
+	defer done()
+	iv := hmacIV.Sum(nil)[:k.Scheme.IVSize()]
+	s, err := newSealer(k, iv)
+	if err != nil {
+		return err
+	}
+
+	// serialize our header
+	header.Scheme, header.IV, header.ChunkSize = k.Scheme, iv, ChunkSize
+	if err = writeHeader(o, header); err != nil {
+		return err
+	}
+
+	// encrypt and write out one chunk at a time, each followed by its MAC
+	buf := make([]byte, ChunkSize, ChunkSize+s.Overhead())
+	for n := uint64(0); ; n++ {
+		m, err := io.ReadFull(plaintext, buf)
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		if _, err := o.Write(s.Seal(buf[:m], n, final)); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// sealer enciphers and authenticates the chunks of one file, in order. Both
+// methods work in place; Seal appends the MAC to the chunk, Open strips it.
+//
+// Every chunk's MAC covers the IV, the chunk's position n and whether it is
+// the last one, so chunks cannot be reordered, spliced in from another file,
+// or dropped off the end.
+type sealer interface {
+	Overhead() int
+	Seal(chunk []byte, n uint64, final bool) []byte
+	Open(chunk []byte, n uint64, final bool) ([]byte, error)
+}
+
+func newSealer(k Key, iv []byte) (sealer, error) {
+	sk, err := k.subkeys()
+	if err != nil {
+		return nil, err
+	}
+	if k.Scheme.AEAD() {
+		a, err := k.Scheme.NewAEAD(sk.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		return &aeadSealer{a, iv, make([]byte, len(iv))}, nil
+	}
+	c, err := k.Scheme.NewCipher(sk.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	return &ctrSealer{cipher.NewCTR(c, iv), hmac.New(k.Scheme.Hash(), sk.MAC), iv}, nil
+}
+
+// chunkPosition encodes a chunk's position and the final flag.
+func chunkPosition(n uint64, final bool) (pos [9]byte) {
+	binary.BigEndian.PutUint64(pos[:8], n)
+	if final {
+		pos[8] = 1
+	}
+	return pos
+}
+
+type ctrSealer struct {
+	s  cipher.Stream
+	h  hash.Hash
+	iv []byte
+}
+
+func (c *ctrSealer) Overhead() int { return c.h.Size() }
+
+func (c *ctrSealer) Seal(chunk []byte, n uint64, final bool) []byte {
+	c.s.XORKeyStream(chunk, chunk)
+	return append(chunk, c.mac(chunk, n, final)...)
+}
+
+func (c *ctrSealer) Open(chunk []byte, n uint64, final bool) ([]byte, error) {
+	chunk, mac := chunk[:len(chunk)-c.h.Size()], chunk[len(chunk)-c.h.Size():]
+	if !hmac.Equal(mac, c.mac(chunk, n, final)) {
+		return nil, fmt.Errorf("unable to verify chunk %d", n)
+	}
+	c.s.XORKeyStream(chunk, chunk)
+	return chunk, nil
+}
+
+func (c *ctrSealer) mac(ciphertext []byte, n uint64, final bool) []byte {
+	pos := chunkPosition(n, final)
+	c.h.Reset()
+	c.h.Write(c.iv)
+	c.h.Write(pos[:])
+	c.h.Write(ciphertext)
+	return c.h.Sum(nil)
+}
+
+type aeadSealer struct {
+	a     cipher.AEAD
+	iv    []byte
+	nonce []byte
+}
+
+func (a *aeadSealer) Overhead() int { return a.a.Overhead() }
+
+func (a *aeadSealer) Seal(chunk []byte, n uint64, final bool) []byte {
+	return a.a.Seal(chunk[:0], a.nonceFor(n, final), chunk, nil)
+}
+
+func (a *aeadSealer) Open(chunk []byte, n uint64, final bool) ([]byte, error) {
+	return a.a.Open(chunk[:0], a.nonceFor(n, final), chunk, nil)
+}
+
+// nonceFor XORs the chunk position into the tail of the IV, the way TLS 1.3
+// builds its per-record nonces.
+func (a *aeadSealer) nonceFor(n uint64, final bool) []byte {
+	pos := chunkPosition(n, final)
+	copy(a.nonce, a.iv)
+	tail := a.nonce[len(a.nonce)-len(pos):]
+	for i := range pos {
+		tail[i] ^= pos[i]
+	}
+	return a.nonce
+}
@@ -0,0 +1,269 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+var (
+	plaintextSize = 1024
+	out           [][]byte
+
+	plaintext = mkRand(plaintextSize)
+	keys      = testKeys()
+)
+
+// a KeyV2 key for every registered scheme, and a KeyV1 key
+func testKeys() []Key {
+	var keys []Key
+	for _, s := range Schemes() {
+		keys = append(keys, Key{KeyV2, s.ID, mkRand(SecretSize)})
+	}
+	return append(keys, Key{KeyV1, AES256_SHA256, mkRand(AES256_SHA256.MACSize() + AES256_SHA256.KeySize())})
+}
+
+func mkRand(sz int) []byte {
+	k := make([]byte, sz)
+	io.ReadFull(rand.Reader, k)
+	return k
+}
+
+func TestEncrypt(t *testing.T) {
+	t.Logf("%25s: %.75s...\n", "plaintext", hex.EncodeToString(plaintext))
+	for _, k := range keys {
+		buf := new(bytes.Buffer)
+		if err := Encrypt(bytes.NewReader(plaintext), buf, k); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%25s: %.75s...\n", k.Scheme, hex.EncodeToString(buf.Bytes()))
+		out = append(out, buf.Bytes())
+	}
+	return
+}
+
+func TestDecrypt(t *testing.T) {
+	t.Logf("%25s: %.75s...\n", "plaintext", hex.EncodeToString(plaintext))
+	for i, k := range keys {
+		x := new(bytes.Buffer)
+		if err := Decrypt(bytes.NewReader(out[i]), x, k); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%25s: %.75s...\n", k.Scheme, hex.EncodeToString(x.Bytes()))
+		if !bytes.Equal(plaintext, x.Bytes()) {
+			t.Fail()
+		}
+	}
+	return
+}
+
+func TestMACFailure(t *testing.T) {
+	for _, k := range keys {
+		var err error
+		buf := new(bytes.Buffer)
+		if err := Encrypt(bytes.NewReader(plaintext), iotest.TruncateWriter(buf, int64(plaintextSize-2)), k); err != nil {
+			t.Fatal(err)
+		}
+		if err = Decrypt(buf, ioutil.Discard, k); err == nil {
+			t.Logf("This should have errored! %s", k.Scheme)
+			t.Fail()
+		} else {
+			t.Logf("%25s: %v\n", k.Scheme, err)
+		}
+	}
+	return
+}
+
+func TestChunked(t *testing.T) {
+	for _, sz := range []int{0, ChunkSize, 3*ChunkSize + 5, spoolMemory + ChunkSize/2} {
+		p := mkRand(sz)
+		for _, k := range keys {
+			a, b := new(bytes.Buffer), new(bytes.Buffer)
+			if err := Encrypt(bytes.NewReader(p), a, k); err != nil {
+				t.Fatal(err)
+			}
+			if err := Encrypt(iotest.OneByteReader(bytes.NewReader(p)), b, k); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(a.Bytes(), b.Bytes()) {
+				t.Errorf("%s: encryption of %d bytes is not deterministic", k.Scheme, sz)
+			}
+			x := new(bytes.Buffer)
+			if err := Decrypt(a, x, k); err != nil {
+				t.Fatalf("%s: %d bytes: %v", k.Scheme, sz, err)
+			}
+			if !bytes.Equal(p, x.Bytes()) {
+				t.Errorf("%s: round trip of %d bytes failed", k.Scheme, sz)
+			}
+		}
+	}
+}
+
+func TestChunkTampering(t *testing.T) {
+	k := keys[0]
+	buf := new(bytes.Buffer)
+	if err := Encrypt(bytes.NewReader(mkRand(2*ChunkSize)), buf, k); err != nil {
+		t.Fatal(err)
+	}
+	ct := buf.Bytes()
+	framed := ChunkSize + k.Scheme.MACSize()
+	headerSize := len(ct) - 2*framed - k.Scheme.MACSize()
+
+	flipped := append([]byte{}, ct...)
+	flipped[headerSize+framed+1] ^= 1
+	swapped := append([]byte{}, ct[:headerSize]...)
+	swapped = append(swapped, ct[headerSize+framed:headerSize+2*framed]...)
+	swapped = append(swapped, ct[headerSize:headerSize+framed]...)
+	swapped = append(swapped, ct[headerSize+2*framed:]...)
+
+	for name, bad := range map[string][]byte{
+		"flipped bit":     flipped,
+		"swapped chunks":  swapped,
+		"dropped chunk":   ct[:headerSize+2*framed],
+		"truncated chunk": ct[:headerSize+framed+10],
+	} {
+		if err := Decrypt(bytes.NewReader(bad), ioutil.Discard, k); err != ErrUnverified {
+			t.Errorf("%s: expected %q, got %v", name, ErrUnverified, err)
+		}
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	k := keys[0]
+	buf := new(bytes.Buffer)
+	if err := Encrypt(bytes.NewReader(plaintext), buf, k); err != nil {
+		t.Fatal(err)
+	}
+	ct := buf.Bytes()
+	newer := append([]byte{}, ct...)
+	newer[len(Magic)] = 0xff
+	damaged := append([]byte{}, ct...)
+	damaged[len(Magic)+1] ^= 0xff
+	tampered := append([]byte{}, ct...)
+	tampered[len(tampered)-1] ^= 1
+	noID := new(bytes.Buffer)
+	if err := encrypt(bytes.NewReader(plaintext), noID, k, Header{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		data []byte
+		key  Key
+		err  error
+	}{
+		{"plaintext", plaintext, k, ErrNotEncrypted},
+		{"empty", nil, k, ErrNotEncrypted},
+		{"newer format", newer, k, ErrUnknownVersion},
+		{"damaged header", damaged, k, ErrMalformedHeader},
+		{"other scheme", ct, keys[1], ErrWrongScheme},
+		{"modified MAC", tampered, k, ErrUnverified},
+		{"wrong key", ct, Key{KeyV2, k.Scheme, mkRand(SecretSize)}, ErrUnknownKey},
+		{"wrong key, no key ID", noID.Bytes(), Key{KeyV2, k.Scheme, mkRand(SecretSize)}, ErrUnverified},
+	} {
+		if err := Decrypt(bytes.NewReader(c.data), ioutil.Discard, c.key); err != c.err {
+			t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestDecryptOrCopy(t *testing.T) {
+	k := keys[0]
+	ct := new(bytes.Buffer)
+	if err := Encrypt(bytes.NewReader(plaintext), ct, k); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name      string
+		in        []byte
+		encrypted bool
+	}{
+		{"ciphertext", ct.Bytes(), true},
+		{"plaintext", plaintext, false},
+		{"empty", nil, false},
+		{"short", []byte(Magic[:3]), false},
+	} {
+		x := new(bytes.Buffer)
+		encrypted, err := DecryptOrCopy(bytes.NewReader(c.in), x, KeyRing{k})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if encrypted != c.encrypted {
+			t.Errorf("%s: expected encrypted=%v", c.name, c.encrypted)
+		}
+		want := c.in
+		if c.encrypted {
+			want = plaintext
+		}
+		if !bytes.Equal(x.Bytes(), want) {
+			t.Errorf("%s: output differs", c.name)
+		}
+	}
+}
+
+func TestReaderWriter(t *testing.T) {
+	p := mkRand(3*ChunkSize + 5)
+	for _, k := range keys {
+		want := new(bytes.Buffer)
+		if err := Encrypt(bytes.NewReader(p), want, k); err != nil {
+			t.Fatal(err)
+		}
+		ct := new(bytes.Buffer)
+		w := NewWriter(ct, k)
+		if _, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(p))); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ct.Bytes(), want.Bytes()) {
+			t.Errorf("%s: Writer and Encrypt disagree", k.Scheme)
+		}
+
+		r, err := NewReader(ct, KeyRing{k})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Header().Scheme != k.Scheme {
+			t.Errorf("%s: header has scheme %s", k.Scheme, r.Header().Scheme)
+		}
+		x, err := ioutil.ReadAll(iotest.OneByteReader(r))
+		if err != nil {
+			t.Fatalf("%s: %v", k.Scheme, err)
+		}
+		if !bytes.Equal(x, p) {
+			t.Errorf("%s: round trip failed", k.Scheme)
+		}
+	}
+
+	// a chunk that fails to verify is never returned
+	ct := new(bytes.Buffer)
+	if err := Encrypt(bytes.NewReader(p), ct, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	bad := ct.Bytes()
+	bad[len(bad)-1] ^= 1
+	r, err := NewReader(bytes.NewReader(bad), KeyRing{keys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := ioutil.ReadAll(r)
+	if err != ErrUnverified {
+		t.Errorf("expected %q, got %v", ErrUnverified, err)
+	}
+	if len(x) != 3*ChunkSize {
+		t.Errorf("expected the %d verified bytes, got %d", 3*ChunkSize, len(x))
+	}
+
+	// a key that can not encrypt fails the writes and Close
+	id, _ := NewIdentity(rand.Reader, DefaultScheme)
+	w := NewWriter(ioutil.Discard, id)
+	w.Write(p)
+	if err := w.Close(); err == nil {
+		t.Errorf("Writer encrypted with an X25519 key")
+	}
+}
@@ -0,0 +1,80 @@
+package grypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"code.google.com/p/go.crypto/scrypt"
+)
+
+// KDFParams are the scrypt parameters for deriving a key from a passphrase
+type KDFParams struct {
+	N, R, P int
+	Salt    []byte
+}
+
+// Bounds on the scrypt parameters. They are read from files that may come
+// from anyone, like a locked key file or a committed PhraseFile, so they
+// must not make grypt spend unbounded memory or time: scrypt uses 128*N*r
+// bytes, and p times as much work.
+const (
+	maxKDFMemory = 1 << 30
+	maxKDFR      = 32
+	maxKDFP      = 16
+)
+
+// ErrKDFParams is returned for scrypt parameters out of those bounds
+var ErrKDFParams = errors.New("scrypt parameters out of bounds")
+
+func (kp KDFParams) check() error {
+	if kp.N < 2 || kp.N&(kp.N-1) != 0 || kp.R < 1 || kp.R > maxKDFR || kp.P < 1 || kp.P > maxKDFP ||
+		kp.N > maxKDFMemory/(128*kp.R) {
+		return ErrKDFParams
+	}
+	return nil
+}
+
+// return parameters with a fresh random salt
+func NewKDFParams(r io.Reader, n, rr, p int) (KDFParams, error) {
+	if err := (KDFParams{N: n, R: rr, P: p}).check(); err != nil {
+		return KDFParams{}, err
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return KDFParams{}, err
+	}
+	return KDFParams{n, rr, p, salt}, nil
+}
+
+// derive a key for scheme s from 'phrase'
+func (kp KDFParams) Key(phrase []byte, s Scheme) (Key, error) {
+	if err := kp.check(); err != nil {
+		return Key{}, err
+	}
+	secret, err := scrypt.Key(phrase, kp.Salt, kp.N, kp.R, kp.P, SecretSize)
+	if err != nil {
+		return Key{}, err
+	}
+	return NewKey(bytes.NewReader(secret), s)
+}
+
+func (kp KDFParams) String() string {
+	return fmt.Sprintf("scrypt N=%d r=%d p=%d salt=%s", kp.N, kp.R, kp.P, base64.StdEncoding.EncodeToString(kp.Salt))
+}
+
+func ParseKDFParams(s string) (KDFParams, error) {
+	var kp KDFParams
+	var salt string
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "scrypt N=%d r=%d p=%d salt=%s", &kp.N, &kp.R, &kp.P, &salt); err != nil {
+		return kp, fmt.Errorf("invalid KDF parameters %q", s)
+	}
+	var err error
+	if kp.Salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return kp, fmt.Errorf("invalid KDF salt %q", salt)
+	}
+	return kp, kp.check()
+}
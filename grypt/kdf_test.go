@@ -0,0 +1,42 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestKDFParams(t *testing.T) {
+	kp, err := NewKDFParams(rand.Reader, 1<<10, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseKDFParams(kp.String() + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.N != kp.N || parsed.R != kp.R || parsed.P != kp.P || !bytes.Equal(parsed.Salt, kp.Salt) {
+		t.Errorf("parameters changed after a round trip: %s became %s", kp, parsed)
+	}
+
+	phrase := []byte("correct horse battery staple")
+	a, err := kp.Key(phrase, DefaultScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := parsed.Key(phrase, DefaultScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Secret, b.Secret) {
+		t.Errorf("the same passphrase and parameters gave different keys")
+	}
+	other, _ := NewKDFParams(rand.Reader, 1<<10, 8, 1)
+	c, err := other.Key(phrase, DefaultScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a.Secret, c.Secret) {
+		t.Errorf("different salts gave the same key")
+	}
+}
@@ -0,0 +1,242 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"code.google.com/p/go.crypto/hkdf"
+)
+
+const (
+	// Keys holding a separate cipher key and HMAC key
+	KeyV1 = 1
+	// Keys holding a single master secret that every other key is derived
+	// from with HKDF
+	KeyV2 = 2
+	// Keys holding an X25519 private key. Files are not encrypted with these
+	// directly but to a list of public keys; see recipients.go.
+	KeyX25519 = 3
+
+	// Size of the master secret in KeyV2 keys
+	SecretSize = 32
+)
+
+var (
+	// A key file holds no keys.
+	ErrNoKeys = errors.New("no keys found")
+	// A key file is locked under a passphrase; see UnlockKeyRing.
+	ErrLocked = errors.New("key file is locked")
+)
+
+type (
+	// Key is used for symmetric encryption in/out of the blob store
+	Key struct {
+		Version int
+		Scheme  Scheme
+		// Master secret. For KeyV1 keys this is the HMAC key followed by
+		// the cipher key.
+		Secret []byte
+	}
+	// On-disk form of KeyV1 keys, which predate the version field
+	keyV1 struct {
+		Scheme Scheme
+		// Symetric cipher key
+		Key []byte
+		// Key for HMAC
+		HMAC []byte
+	}
+	// The keys a Key hands to each primitive
+	subkeys struct {
+		// Symetric cipher or AEAD key
+		Cipher []byte
+		// Key for the HMAC that derives the synthetic IV
+		IV []byte
+		// Key for the chunk HMACs (unused by AEAD schemes)
+		MAC []byte
+	}
+	// KeyRing holds several keys. The first one encrypts; all of them can
+	// decrypt, so files from before a rotation stay readable.
+	KeyRing []Key
+)
+
+// return a Key from the supplied Reader
+func NewKey(r io.Reader, s Scheme) (Key, error) {
+	if _, err := s.info(); err != nil {
+		return Key{}, err
+	}
+	secret := make([]byte, SecretSize)
+	if _, err := io.ReadFull(r, secret); err != nil {
+		return Key{}, err
+	}
+	return Key{KeyV2, s, secret}, nil
+}
+
+// Reports whether a key with the same secret is in the ring
+func (r KeyRing) Has(k Key) bool {
+	for _, x := range r {
+		if bytes.Equal(x.Secret, k.Secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Short identifier of the key, written into the Header of files it encrypts
+func (k Key) ID() []byte {
+	id := make([]byte, 8)
+	io.ReadFull(hkdf.New(sha256.New, k.Secret, nil, []byte("grypt key id")), id)
+	return id
+}
+
+// Derive the keys for each primitive. KeyV1 keys use their HMAC key for
+// both the synthetic IV and the chunk MACs; KeyV2 keys get a distinct HKDF
+// output for every purpose.
+func (k Key) subkeys() (subkeys, error) {
+	if _, err := k.Scheme.info(); err != nil {
+		return subkeys{}, err
+	}
+	size := SecretSize
+	if k.Version == KeyV1 {
+		size = k.Scheme.MACSize() + k.Scheme.KeySize()
+	}
+	if len(k.Secret) != size {
+		return subkeys{}, fmt.Errorf("malformed key")
+	}
+	switch k.Version {
+	case KeyV1:
+		mac := k.Secret[:k.Scheme.MACSize()]
+		return subkeys{k.Secret[k.Scheme.MACSize():], mac, mac}, nil
+	case KeyV2:
+		sk := subkeys{
+			make([]byte, k.Scheme.KeySize()),
+			make([]byte, k.Scheme.MACSize()),
+			make([]byte, k.Scheme.MACSize()),
+		}
+		for _, d := range []struct {
+			info string
+			out  []byte
+		}{
+			{"grypt cipher key", sk.Cipher},
+			{"grypt iv key", sk.IV},
+			{"grypt mac key", sk.MAC},
+		} {
+			r := hkdf.New(k.Scheme.Hash(), k.Secret, nil, []byte(d.info))
+			if _, err := io.ReadFull(r, d.out); err != nil {
+				return subkeys{}, err
+			}
+		}
+		return sk, nil
+	case KeyX25519:
+		return subkeys{}, fmt.Errorf("X25519 keys can only encrypt to recipients")
+	default:
+		return subkeys{}, fmt.Errorf("unknown key version %d", k.Version)
+	}
+}
+
+// MarshalKey serializes a key in the DER format of its version.
+func MarshalKey(k Key) ([]byte, error) {
+	switch k.Version {
+	case KeyV1:
+		sk, err := k.subkeys()
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(keyV1{k.Scheme, sk.Cipher, sk.MAC})
+	case KeyV2, KeyX25519:
+		return asn1.Marshal(k)
+	default:
+		return nil, fmt.Errorf("unknown key version %d", k.Version)
+	}
+}
+
+// UnmarshalKey parses a key of any version from its DER form.
+func UnmarshalKey(bits []byte) (Key, error) {
+	k := Key{}
+	if _, err := asn1.Unmarshal(bits, &k); err == nil {
+		if k.Version == KeyShare {
+			return Key{}, fmt.Errorf("a share of a key, which has to be combined with others first")
+		}
+		if k.Version != KeyV2 && k.Version != KeyX25519 {
+			return Key{}, fmt.Errorf("unknown key version %d", k.Version)
+		}
+		if _, ok := k.Scheme.Info(); !ok {
+			return Key{}, ErrInvalidScheme
+		}
+		if len(k.Secret) != SecretSize {
+			return Key{}, fmt.Errorf("malformed key")
+		}
+		return k, nil
+	}
+
+	// keys from before the version field
+	old := keyV1{}
+	if _, err := asn1.Unmarshal(bits, &old); err != nil {
+		return Key{}, err
+	}
+	if _, ok := old.Scheme.Info(); !ok {
+		return Key{}, ErrInvalidScheme
+	}
+	if len(old.Key) != old.Scheme.KeySize() || len(old.HMAC) != old.Scheme.MACSize() {
+		return Key{}, fmt.Errorf("malformed key")
+	}
+	return Key{KeyV1, old.Scheme, append(old.HMAC, old.Key...)}, nil
+}
+
+// MarshalKeyRing base64 encodes the keys in 'ring', one per line, which is
+// the format of a key file.
+func MarshalKeyRing(ring KeyRing) ([]byte, error) {
+	if len(ring) == 0 {
+		return nil, ErrNoKeys
+	}
+	buf := new(bytes.Buffer)
+	for _, k := range ring {
+		bits, err := MarshalKey(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(base64.StdEncoding.EncodeToString(bits))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseKeyRing decodes the contents of a key file. A file holding a single
+// key is a ring of one. Locked key files have to go through UnlockKeyRing
+// instead; they give ErrLocked.
+func ParseKeyRing(bits []byte) (KeyRing, error) {
+	if _, ok := LockedKDFParams(bits); ok {
+		return nil, ErrLocked
+	}
+	var ring KeyRing
+	for _, line := range strings.Fields(string(bits)) {
+		der, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, err
+		}
+		k, err := UnmarshalKey(der)
+		if err != nil {
+			return nil, err
+		}
+		ring = append(ring, k)
+	}
+	if len(ring) == 0 {
+		return nil, ErrNoKeys
+	}
+	return ring, nil
+}
+
+// ReadKeyRing is ParseKeyRing for a key file read from r.
+func ReadKeyRing(r io.Reader) (KeyRing, error) {
+	bits, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyRing(bits)
+}
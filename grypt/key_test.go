@@ -0,0 +1,118 @@
+package grypt
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestKeyV1(t *testing.T) {
+	old := keyV1{AES256_SHA256, mkRand(AES256_SHA256.KeySize()), mkRand(AES256_SHA256.MACSize())}
+	bits, err := asn1.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := UnmarshalKey(bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Version != KeyV1 {
+		t.Fatalf("expected a version %d key, got %d", KeyV1, k.Version)
+	}
+	sk, err := k.subkeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sk.Cipher, old.Key) || !bytes.Equal(sk.IV, old.HMAC) || !bytes.Equal(sk.MAC, old.HMAC) {
+		t.Errorf("version 1 key was not read back as written")
+	}
+
+	// marshaling it again must not upgrade the format
+	again, err := MarshalKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, bits) {
+		t.Errorf("version 1 key was marshaled differently:\nexpected %x\ngot      %x", bits, again)
+	}
+}
+
+func TestSubkeys(t *testing.T) {
+	for _, k := range keys {
+		if k.Version != KeyV2 {
+			continue
+		}
+		sk, err := k.subkeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(sk.IV, sk.MAC) || bytes.Equal(sk.Cipher[:16], sk.IV[:16]) {
+			t.Errorf("%s: derived keys are not distinct", k.Scheme)
+		}
+		if len(sk.Cipher) != k.Scheme.KeySize() || len(sk.MAC) != k.Scheme.MACSize() {
+			t.Errorf("%s: derived keys have the wrong size", k.Scheme)
+		}
+	}
+
+	// a Key built by hand with a short secret is an error, not a panic
+	for _, v := range []int{KeyV1, KeyV2} {
+		k := Key{v, AES256_SHA256, mkRand(3)}
+		if err := Encrypt(bytes.NewReader(plaintext), ioutil.Discard, k); err == nil {
+			t.Errorf("version %d key with a 3 byte secret encrypted", v)
+		}
+	}
+}
+
+func TestKeyRing(t *testing.T) {
+	ring := KeyRing{keys[0], keys[len(keys)-1], keys[1]}
+	bits, err := MarshalKeyRing(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadKeyRing(bytes.NewReader(bits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(ring) {
+		t.Fatalf("expected %d keys, got %d", len(ring), len(read))
+	}
+	for n := range ring {
+		if !bytes.Equal(read[n].Secret, ring[n].Secret) || read[n].Version != ring[n].Version {
+			t.Errorf("key %d changed after a round trip", n)
+		}
+	}
+
+	// a single key without a trailing newline, as the grypt command writes
+	// key files, is a ring of one
+	single, _ := MarshalKey(keys[1])
+	if one, err := ParseKeyRing([]byte(base64.StdEncoding.EncodeToString(single))); err != nil || len(one) != 1 {
+		t.Errorf("single key: %d keys, %v", len(one), err)
+	}
+	if _, err = ParseKeyRing([]byte("\n")); err != ErrNoKeys {
+		t.Errorf("expected %q, got %v", ErrNoKeys, err)
+	}
+	if _, err = ParseKeyRing([]byte(strings.Replace(string(bits), "A", "!", 1))); err == nil {
+		t.Errorf("a damaged key ring was parsed")
+	}
+
+	// the same scheme twice: the key ID picks the right one, and without
+	// an ID the first chunk does
+	for n, k := range ring[:2] {
+		for _, header := range []Header{{KeyID: k.ID()}, {}} {
+			ct, x := new(bytes.Buffer), new(bytes.Buffer)
+			if err := encrypt(bytes.NewReader(plaintext), ct, k, header); err != nil {
+				t.Fatal(err)
+			}
+			if err := DecryptRing(ct, x, ring); err != nil {
+				t.Fatalf("key %d, key ID %x: %v", n, header.KeyID, err)
+			}
+			if !bytes.Equal(x.Bytes(), plaintext) {
+				t.Errorf("key %d, key ID %x: round trip failed", n, header.KeyID)
+			}
+		}
+	}
+}
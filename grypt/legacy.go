@@ -0,0 +1,111 @@
+package grypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"io"
+
+	"polydawn.net/grypt/internal/stream"
+)
+
+/*
+The first grypt wrote no Magic and no chunks: a file is a bare ASN.1
+legacyHeader, then the whole plaintext enciphered in CTR mode. The MAC in
+the header is an HMAC of all of that ciphertext, so nothing can be written
+out before the last byte has been read. Only KeyV1 keys, which are the keys
+of that grypt, decrypt it; nothing writes it any more.
+*/
+
+// ErrLegacy is returned for data in the format of the first grypt where it
+// can not be read: by ReadHeader and NewReader, and by DecryptRing when no
+// version 1 key of the file's scheme is at hand.
+var ErrLegacy = errors.New("data is in the original grypt format, which only a version 1 key decrypts")
+
+type legacyHeader struct {
+	Scheme Scheme
+	IV     []byte
+	MAC    []byte
+}
+
+// IsLegacy reports whether the data in b starts with the header of the
+// original grypt format, without consuming any of it.
+func IsLegacy(b *bufio.Reader) bool {
+	// those headers are short enough for a one byte length
+	prefix, _ := b.Peek(2)
+	if len(prefix) < 2 || prefix[0] != 0x30 || prefix[1]&0x80 != 0 {
+		return false
+	}
+	bits, _ := b.Peek(2 + int(prefix[1]))
+	_, err := parseLegacyHeader(bits)
+	return err == nil
+}
+
+func parseLegacyHeader(bits []byte) (legacyHeader, error) {
+	var h legacyHeader
+	if rest, err := asn1.Unmarshal(bits, &h); err != nil || len(rest) != 0 {
+		return h, ErrMalformedHeader
+	}
+	info, ok := h.Scheme.Info()
+	if !ok || info.NewCipher == nil || len(h.IV) != info.BlockSize || len(h.MAC) != info.Hash().Size() {
+		return h, ErrMalformedHeader
+	}
+	return h, nil
+}
+
+// decryptLegacy decrypts a file in the original format with whichever
+// version 1 key in ring verifies its MAC.
+func decryptLegacy(b *bufio.Reader, o io.Writer, ring KeyRing) error {
+	bits, err := stream.ReadDER(b, maxHeaderSize)
+	if err != nil {
+		return ErrMalformedHeader
+	}
+	header, err := parseLegacyHeader(bits)
+	if err != nil {
+		return err
+	}
+	var keys []Key
+	var macs []hash.Hash
+	var ws []io.Writer
+	for _, k := range ring {
+		if k.Version != KeyV1 || k.Scheme != header.Scheme {
+			continue
+		}
+		sk, err := k.subkeys()
+		if err != nil {
+			return err
+		}
+		h := hmac.New(k.Scheme.Hash(), sk.MAC)
+		keys, macs, ws = append(keys, k), append(macs, h), append(ws, h)
+	}
+	if len(keys) == 0 {
+		return ErrLegacy
+	}
+
+	// the MAC covers the whole file, so it is all read before any of it is
+	// deciphered
+	ciphertext, done, err := stream.Spool(b, io.MultiWriter(ws...), spoolMemory)
+	if err != nil {
+		return err
+	}
+	defer done()
+	for n, k := range keys {
+		if !hmac.Equal(header.MAC, macs[n].Sum(nil)) {
+			continue
+		}
+		sk, err := k.subkeys()
+		if err != nil {
+			return err
+		}
+		c, err := k.Scheme.NewCipher(sk.Cipher)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(cipher.StreamWriter{S: cipher.NewCTR(c, header.IV), W: o}, ciphertext)
+		return err
+	}
+	return ErrUnverified
+}
@@ -0,0 +1,70 @@
+package grypt
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Files and keys in testdata were written by the first grypt, before Magic
+// and chunking.
+func TestLegacy(t *testing.T) {
+	plain, err := ioutil.ReadFile(filepath.Join("testdata", "legacy.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"legacy-aes256sha256", "legacy-blowfish448blake2512"} {
+		f, err := os.Open(filepath.Join("testdata", name+".key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ring, err := ReadKeyRing(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ring[0].Version != KeyV1 {
+			t.Fatalf("%s: expected a version %d key, got %d", name, KeyV1, ring[0].Version)
+		}
+		ciphertext, err := ioutil.ReadFile(filepath.Join("testdata", name+".grypt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsLegacy(bufio.NewReader(bytes.NewReader(ciphertext))) {
+			t.Errorf("%s: not recognised as the original format", name)
+		}
+		if _, _, err = ReadHeader(bytes.NewReader(ciphertext)); err != ErrLegacy {
+			t.Errorf("%s: expected %q from ReadHeader, got %v", name, ErrLegacy, err)
+		}
+
+		// among other keys, and through DecryptOrCopy as the filters do
+		got := new(bytes.Buffer)
+		encrypted, err := DecryptOrCopy(bytes.NewReader(ciphertext), got, KeyRing{keys[0], keys[len(keys)-1], ring[0]})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !encrypted || !bytes.Equal(got.Bytes(), plain) {
+			t.Errorf("%s: not decrypted to the plaintext it was made from", name)
+		}
+
+		if err = DecryptRing(bytes.NewReader(ciphertext), ioutil.Discard, KeyRing{keys[0]}); err != ErrLegacy {
+			t.Errorf("%s: expected %q without a version 1 key, got %v", name, ErrLegacy, err)
+		}
+		damaged := append([]byte(nil), ciphertext...)
+		damaged[len(damaged)-1] ^= 1
+		got.Reset()
+		if err = DecryptRing(bytes.NewReader(damaged), got, ring); err != ErrUnverified {
+			t.Errorf("%s: expected %q for a damaged file, got %v", name, ErrUnverified, err)
+		}
+		if got.Len() != 0 {
+			t.Errorf("%s: plaintext of a damaged file was written out", name)
+		}
+	}
+
+	if IsLegacy(bufio.NewReader(bytes.NewReader(plaintext))) {
+		t.Error("random data taken for the original format")
+	}
+}
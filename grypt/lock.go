@@ -0,0 +1,108 @@
+package grypt
+
+import (
+	"crypto/cipher"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"code.google.com/p/go.crypto/scrypt"
+	"polydawn.net/grypt/ext/chacha20poly1305"
+)
+
+// Version field of a key file sealed under a passphrase. It shares the
+// numbering of the key versions so neither is mistaken for the other.
+const KeyLocked = 4
+
+// ErrBadPassphrase is returned when a locked key file does not open
+var ErrBadPassphrase = errors.New("wrong passphrase for key file")
+
+// lockedKeys is a key file encrypted with XChaCha20-Poly1305 under a key
+// derived from a passphrase. Sealed holds the unlocked file as written by
+// MarshalKeyRing.
+type lockedKeys struct {
+	Version int
+	KDF     KDFParams
+	Nonce   []byte
+	Sealed  []byte
+}
+
+// decode 'bits' as a locked key file
+func readLocked(bits []byte) (lockedKeys, bool) {
+	var l lockedKeys
+	lines := strings.Fields(string(bits))
+	if len(lines) != 1 {
+		return l, false
+	}
+	der, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return l, false
+	}
+	if _, err = asn1.Unmarshal(der, &l); err != nil || l.Version != KeyLocked {
+		return l, false
+	}
+	return l, true
+}
+
+// LockedKDFParams reports whether 'bits' is a locked key file, and how its
+// passphrase is turned into a key.
+func LockedKDFParams(bits []byte) (KDFParams, bool) {
+	l, ok := readLocked(bits)
+	return l.KDF, ok
+}
+
+// LockKeyRing returns the contents of a key file holding 'ring', sealed
+// under 'phrase'. The nonce is read from r.
+func LockKeyRing(r io.Reader, ring KeyRing, phrase []byte, kp KDFParams) ([]byte, error) {
+	plain, err := MarshalKeyRing(ring)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := lockCipher(phrase, kp)
+	if err != nil {
+		return nil, err
+	}
+	l := lockedKeys{Version: KeyLocked, KDF: kp, Nonce: make([]byte, aead.NonceSize())}
+	if _, err = io.ReadFull(r, l.Nonce); err != nil {
+		return nil, err
+	}
+	l.Sealed = aead.Seal(nil, l.Nonce, plain, nil)
+	der, err := asn1.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(der) + "\n"), nil
+}
+
+// UnlockKeyRing opens the locked key file 'bits' with 'phrase'.
+func UnlockKeyRing(bits, phrase []byte) (KeyRing, error) {
+	l, ok := readLocked(bits)
+	if !ok {
+		return nil, errors.New("key file is not locked")
+	}
+	if len(l.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, errors.New("malformed locked key file")
+	}
+	aead, err := lockCipher(phrase, l.KDF)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, l.Nonce, l.Sealed, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return ParseKeyRing(plain)
+}
+
+func lockCipher(phrase []byte, kp KDFParams) (cipher.AEAD, error) {
+	if err := kp.check(); err != nil {
+		return nil, err
+	}
+	k, err := scrypt.Key(phrase, kp.Salt, kp.N, kp.R, kp.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(k)
+}
@@ -0,0 +1,58 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/base64"
+	"testing"
+)
+
+func TestLockKeyRing(t *testing.T) {
+	ring := KeyRing{keys[0], keys[1]}
+	kp, err := NewKDFParams(rand.Reader, 1<<10, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := LockKeyRing(rand.Reader, ring, []byte("hunter2"), kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := LockedKDFParams(bits); !ok || got.N != kp.N || !bytes.Equal(got.Salt, kp.Salt) {
+		t.Fatal("locked key ring does not carry its KDF parameters")
+	}
+	if _, err = ParseKeyRing(bits); err != ErrLocked {
+		t.Errorf("expected %q, got %v", ErrLocked, err)
+	}
+	if _, err = UnlockKeyRing(bits, []byte("hunter3")); err != ErrBadPassphrase {
+		t.Errorf("expected %q, got %v", ErrBadPassphrase, err)
+	}
+	read, err := UnlockKeyRing(bits, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(ring) || !bytes.Equal(read[1].Secret, ring[1].Secret) {
+		t.Fatal("keys changed after locking")
+	}
+
+	// a crafted file is refused before the passphrase is tried
+	l, _ := readLocked(bits)
+	for _, bad := range []func(*lockedKeys){
+		func(l *lockedKeys) { l.Nonce = l.Nonce[:5] },
+		func(l *lockedKeys) { l.KDF.N = 1 << 30 },
+		func(l *lockedKeys) { l.KDF.N = 1000 },
+		func(l *lockedKeys) { l.KDF.P = 1 << 20 },
+		func(l *lockedKeys) { l.KDF.R = 0 },
+	} {
+		c := l
+		c.KDF.Salt, c.Nonce = append([]byte(nil), l.KDF.Salt...), append([]byte(nil), l.Nonce...)
+		bad(&c)
+		der, err := asn1.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := UnlockKeyRing([]byte(base64.StdEncoding.EncodeToString(der)), []byte("hunter2")); err == nil || err == ErrBadPassphrase {
+			t.Errorf("nonce of %d bytes, %s: got %v", len(c.Nonce), c.KDF, err)
+		}
+	}
+}
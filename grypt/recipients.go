@@ -0,0 +1,187 @@
+package grypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"code.google.com/p/go.crypto/curve25519"
+	"code.google.com/p/go.crypto/hkdf"
+	"code.google.com/p/go.crypto/nacl/box"
+)
+
+/*
+Instead of sharing one Key, a repository can list the X25519 public keys of
+everyone who may read it (the grypt command keeps them in .grypt-recipients).
+Each file then gets a data key of its own, which is sealed in a NaCl box to
+every recipient and kept in the Header.
+
+The box's ephemeral key is derived from the data key, so encrypting the same
+plaintext with the same data key to the same recipients always gives the same
+bytes. The clean filter keeps a file's data key for as long as nobody is
+removed from its recipients, which keeps git from seeing spurious changes.
+*/
+
+type (
+	// An X25519 public key
+	PublicKey [32]byte
+	// Recipient holds a file's data key sealed to one public key
+	Recipient struct {
+		PublicKey []byte
+		DataKey   []byte
+	}
+)
+
+// return an X25519 Key from the supplied Reader, to encrypt data keys for
+// scheme s
+func NewIdentity(r io.Reader, s Scheme) (Key, error) {
+	if _, err := s.info(); err != nil {
+		return Key{}, err
+	}
+	private := make([]byte, SecretSize)
+	if _, err := io.ReadFull(r, private); err != nil {
+		return Key{}, err
+	}
+	return Key{KeyX25519, s, private}, nil
+}
+
+// The public half of an X25519 Key
+func (k Key) PublicKey() (PublicKey, error) {
+	var pub, private PublicKey
+	if k.Version != KeyX25519 {
+		return pub, fmt.Errorf("not an X25519 key")
+	}
+	copy(private[:], k.Secret)
+	curve25519.ScalarBaseMult((*[32]byte)(&pub), (*[32]byte)(&private))
+	return pub, nil
+}
+
+func ParsePublicKey(s string) (PublicKey, error) {
+	var pub PublicKey
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != len(pub) {
+		return pub, fmt.Errorf("invalid public key %q", s)
+	}
+	copy(pub[:], b)
+	return pub, nil
+}
+
+func (p PublicKey) String() string {
+	return base64.StdEncoding.EncodeToString(p[:])
+}
+
+// ParseRecipients reads public keys from r, one per line, each optionally
+// followed by a name. Blank lines and lines starting with # are skipped.
+func ParseRecipients(r io.Reader) ([]PublicKey, error) {
+	var keys []PublicKey
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		pub, err := ParsePublicKey(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pub)
+	}
+	return keys, s.Err()
+}
+
+// EncryptTo encrypts plaintext under dataKey, which must be a KeyV2 key,
+// and seals dataKey to each of the recipients.
+func EncryptTo(i io.Reader, o io.Writer, dataKey Key, recipients []PublicKey) error {
+	if dataKey.Version != KeyV2 {
+		return fmt.Errorf("data keys must be version %d keys", KeyV2)
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+	private, public, err := ephemeral(dataKey)
+	if err != nil {
+		return err
+	}
+	sorted := make([]PublicKey, len(recipients))
+	copy(sorted, recipients)
+	sort.Sort(publicKeys(sorted))
+
+	header := Header{Ephemeral: public[:]}
+	var nonce [24]byte
+	for n, pub := range sorted {
+		if n > 0 && pub == sorted[n-1] {
+			continue
+		}
+		p := [32]byte(pub)
+		sealed := box.Seal(nil, dataKey.Secret, &nonce, &p, &private)
+		header.Recipients = append(header.Recipients, Recipient{pub[:], sealed})
+	}
+	return encrypt(i, o, dataKey, header)
+}
+
+// ephemeral derives the key pair dataKey is sealed with. Every box uses a
+// different shared key and always seals the same data key, so the nonce
+// can stay zero.
+func ephemeral(dataKey Key) (private, public [32]byte, err error) {
+	r := hkdf.New(sha256.New, dataKey.Secret, nil, []byte("grypt ephemeral key"))
+	if _, err = io.ReadFull(r, private[:]); err != nil {
+		return
+	}
+	curve25519.ScalarBaseMult(&public, &private)
+	return
+}
+
+// unwrapKey opens the data key that header holds for identity.
+func unwrapKey(header Header, identity Key) (Key, error) {
+	pub, err := identity.PublicKey()
+	if err != nil {
+		return Key{}, err
+	}
+	if len(header.Ephemeral) != 32 {
+		return Key{}, ErrMalformedHeader
+	}
+	var eph, private [32]byte
+	var nonce [24]byte
+	copy(eph[:], header.Ephemeral)
+	copy(private[:], identity.Secret)
+	for _, r := range header.Recipients {
+		if !bytes.Equal(r.PublicKey, pub[:]) {
+			continue
+		}
+		secret, ok := box.Open(nil, r.DataKey, &nonce, &eph, &private)
+		if !ok || len(secret) != SecretSize {
+			return Key{}, ErrUnverified
+		}
+		return Key{KeyV2, header.Scheme, secret}, nil
+	}
+	return Key{}, ErrNotRecipient
+}
+
+// DataKey returns the data key and recipients of the file in i, as seen by
+// identity.
+func DataKey(i io.Reader, identity Key) (Key, []PublicKey, error) {
+	header, _, err := ReadHeader(i)
+	if err != nil {
+		return Key{}, nil, err
+	}
+	k, err := unwrapKey(header, identity)
+	if err != nil {
+		return Key{}, nil, err
+	}
+	recipients := make([]PublicKey, len(header.Recipients))
+	for n, r := range header.Recipients {
+		copy(recipients[n][:], r.PublicKey)
+	}
+	return k, recipients, nil
+}
+
+type publicKeys []PublicKey
+
+func (p publicKeys) Len() int           { return len(p) }
+func (p publicKeys) Less(i, j int) bool { return bytes.Compare(p[i][:], p[j][:]) < 0 }
+func (p publicKeys) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,91 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+func mkIdentity(t *testing.T) (Key, PublicKey) {
+	k, err := NewIdentity(rand.Reader, DefaultScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, pub
+}
+
+func TestEncryptTo(t *testing.T) {
+	alice, alicePub := mkIdentity(t)
+	bob, bobPub := mkIdentity(t)
+	eve, _ := mkIdentity(t)
+	dataKey, err := NewKey(rand.Reader, DefaultScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := new(bytes.Buffer), new(bytes.Buffer)
+	if err := EncryptTo(bytes.NewReader(plaintext), a, dataKey, []PublicKey{alicePub, bobPub}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptTo(bytes.NewReader(plaintext), b, dataKey, []PublicKey{bobPub, alicePub}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Errorf("encryption to the same recipients is not deterministic")
+	}
+
+	for name, k := range map[string]Key{"alice": alice, "bob": bob} {
+		x := new(bytes.Buffer)
+		if err := Decrypt(bytes.NewReader(a.Bytes()), x, k); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(x.Bytes(), plaintext) {
+			t.Errorf("%s: round trip failed", name)
+		}
+	}
+	if err := Decrypt(bytes.NewReader(a.Bytes()), ioutil.Discard, eve); err != ErrNotRecipient {
+		t.Errorf("eve: expected %q, got %v", ErrNotRecipient, err)
+	}
+	if err := Decrypt(bytes.NewReader(a.Bytes()), ioutil.Discard, dataKey); err != ErrNotRecipient {
+		t.Errorf("data key: expected %q, got %v", ErrNotRecipient, err)
+	}
+
+	k, recipients, err := DataKey(bytes.NewReader(a.Bytes()), bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k.Secret, dataKey.Secret) {
+		t.Errorf("DataKey returned the wrong key")
+	}
+	want := map[PublicKey]bool{alicePub: true, bobPub: true}
+	if len(recipients) != 2 || recipients[0] == recipients[1] || !want[recipients[0]] || !want[recipients[1]] {
+		t.Errorf("DataKey returned the wrong recipients: %v", recipients)
+	}
+}
+
+func TestManyRecipients(t *testing.T) {
+	var recipients []PublicKey
+	var last Key
+	for i := 0; i < 50; i++ {
+		k, pub := mkIdentity(t)
+		recipients = append(recipients, pub)
+		last = k
+	}
+	dataKey, _ := NewKey(rand.Reader, DefaultScheme)
+	buf := new(bytes.Buffer)
+	if err := EncryptTo(bytes.NewReader(plaintext), buf, dataKey, recipients); err != nil {
+		t.Fatal(err)
+	}
+	x := new(bytes.Buffer)
+	if err := Decrypt(buf, x, last); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(x.Bytes(), plaintext) {
+		t.Errorf("round trip failed")
+	}
+}
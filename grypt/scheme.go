@@ -0,0 +1,282 @@
+package grypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"code.google.com/p/go.crypto/blowfish"
+	"code.google.com/p/go.crypto/sha3"
+	"polydawn.net/grypt/ext/blake2b"
+	"polydawn.net/grypt/ext/chacha20poly1305"
+	"polydawn.net/grypt/ext/gcmsiv"
+)
+
+const (
+	// Use AES-256 with a SHA-256 HMAC
+	AES256_SHA256 Scheme = iota
+	// Use AES-256 with a Keccak-256 (SHA3) HMAC
+	AES256_Keccak256
+	// Use Blowfish-448 with a SHA-256 HMAC
+	Blowfish448_SHA256
+	// Use AES-265 with a BLAKE2-256 HMAC
+	AES256_BLAKE2256
+	// Use Blowfish-448 with a BLAKE2-512 HMAC
+	Blowfish448_BLAKE2512
+	// Use AES-256 in GCM-SIV mode
+	AES256_GCMSIV
+	// Use XChaCha20-Poly1305 with a synthetic nonce
+	XChaCha20_Poly1305
+)
+
+var (
+	// Scheme used for new keys.
+	DefaultScheme = AES256_SHA256
+	// The scheme indicated does not exist or is not supported.
+	ErrInvalidScheme = fmt.Errorf("invalid scheme")
+)
+
+type (
+	// Encryption scheme, as recorded in keys and headers. What each one
+	// means is looked up in the registry; see RegisterScheme.
+	Scheme int
+
+	// SchemeInfo describes an encryption scheme: either a block cipher run
+	// in CTR mode with an HMAC after every chunk, or an AEAD.
+	SchemeInfo struct {
+		ID Scheme
+		// How the scheme is printed, e.g. "AES-256/SHA-256"
+		Name string
+		// The name ParseScheme accepts, e.g. "aes256sha256", and any
+		// shorter ones
+		Canonical string
+		Aliases   []string
+		// Size of the cipher key, and of the HMAC key. AEAD schemes only
+		// use the HMAC key to derive the synthetic IV.
+		KeySize, MACSize int
+		BlockSize        int
+		// Size of the synthetic IV: a cipher block for CTR schemes, a
+		// nonce for AEADs
+		IVSize int
+		// Exactly one of NewCipher and NewAEAD is set
+		NewCipher func(key []byte) (cipher.Block, error)
+		NewAEAD   func(key []byte) (cipher.AEAD, error)
+		// The hash for the HMACs
+		Hash func() hash.Hash
+		// Deprecated schemes still decrypt, but should not be used for
+		// new keys. None of the built in ones are.
+		Deprecated bool
+	}
+)
+
+// registered schemes, in the order they were registered. The built in ones
+// are set up by an initializer rather than init, so that they are there for
+// the package's other initializers.
+var schemes = builtinSchemes()
+
+func builtinSchemes() []*SchemeInfo {
+	var list []*SchemeInfo
+	for _, s := range []SchemeInfo{
+		{
+			ID: AES256_SHA256, Name: "AES-256/SHA-256", Canonical: "aes256sha256",
+			KeySize: 32, MACSize: 32, BlockSize: aes.BlockSize, IVSize: aes.BlockSize,
+			NewCipher: aes.NewCipher, Hash: sha256.New,
+		},
+		{
+			ID: AES256_Keccak256, Name: "AES-256/Keccak-256", Canonical: "aes256keccak256", Aliases: []string{"keccak"},
+			KeySize: 32, MACSize: 32, BlockSize: aes.BlockSize, IVSize: aes.BlockSize,
+			NewCipher: aes.NewCipher, Hash: sha3.NewKeccak256,
+		},
+		{
+			ID: AES256_BLAKE2256, Name: "AES-256/BLAKE2-256", Canonical: "aes256blake2256", Aliases: []string{"blake2"},
+			KeySize: 32, MACSize: 32, BlockSize: aes.BlockSize, IVSize: aes.BlockSize,
+			NewCipher: aes.NewCipher, Hash: blake2b.New256,
+		},
+		{
+			ID: Blowfish448_SHA256, Name: "Blowfish-448/SHA-256", Canonical: "blowfish448sha256", Aliases: []string{"blowfish"},
+			KeySize: 56, MACSize: 32, BlockSize: blowfish.BlockSize, IVSize: blowfish.BlockSize,
+			NewCipher: newBlowfish, Hash: sha256.New,
+		},
+		{
+			ID: Blowfish448_BLAKE2512, Name: "Blowfish-448/BLAKE2-512", Canonical: "blowfish448blake2512", Aliases: []string{"blakefish"},
+			KeySize: 56, MACSize: 64, BlockSize: blowfish.BlockSize, IVSize: blowfish.BlockSize,
+			NewCipher: newBlowfish, Hash: blake2b.New512,
+		},
+		{
+			ID: AES256_GCMSIV, Name: "AES-256-GCM-SIV", Canonical: "aes256gcmsiv", Aliases: []string{"gcmsiv"},
+			KeySize: 32, MACSize: 32, BlockSize: aes.BlockSize, IVSize: gcmsiv.NonceSize,
+			NewAEAD: gcmsiv.New, Hash: sha256.New,
+		},
+		{
+			ID: XChaCha20_Poly1305, Name: "XChaCha20-Poly1305", Canonical: "xchacha20poly1305", Aliases: []string{"xchacha"},
+			KeySize: 32, MACSize: 32, BlockSize: 64, IVSize: chacha20poly1305.NonceSizeX,
+			NewAEAD: chacha20poly1305.NewX, Hash: sha256.New,
+		},
+	} {
+		list = register(list, s)
+	}
+	return list
+}
+
+func newBlowfish(key []byte) (cipher.Block, error) {
+	return blowfish.NewCipher(key)
+}
+
+// RegisterScheme makes a scheme known to ParseScheme, Schemes and the
+// methods of Scheme. It panics if the descriptor is incomplete, or if its
+// ID or one of its names is taken.
+func RegisterScheme(info SchemeInfo) {
+	schemes = register(schemes, info)
+}
+
+func register(list []*SchemeInfo, info SchemeInfo) []*SchemeInfo {
+	if info.Name == "" || info.Canonical == "" || info.KeySize <= 0 || info.MACSize <= 0 || info.IVSize <= 0 || info.Hash == nil {
+		panic(fmt.Sprintf("grypt: incomplete scheme %d", info.ID))
+	}
+	if (info.NewCipher == nil) == (info.NewAEAD == nil) {
+		panic(fmt.Sprintf("grypt: scheme %s needs exactly one of NewCipher and NewAEAD", info.Name))
+	}
+	for _, s := range list {
+		if s.ID == info.ID {
+			panic(fmt.Sprintf("grypt: scheme %d registered twice", info.ID))
+		}
+	}
+	for _, name := range append([]string{info.Canonical}, info.Aliases...) {
+		if name == "default" || findScheme(list, name) != nil {
+			panic(fmt.Sprintf("grypt: scheme name %q registered twice", name))
+		}
+	}
+	info.Aliases = append([]string(nil), info.Aliases...)
+	return append(list, &info)
+}
+
+func findScheme(list []*SchemeInfo, name string) *SchemeInfo {
+	for _, s := range list {
+		if name == s.Canonical || contains(s.Aliases, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Schemes lists the registered schemes, in the order they were registered.
+func Schemes() []SchemeInfo {
+	list := make([]SchemeInfo, len(schemes))
+	for n, s := range schemes {
+		list[n] = *s
+	}
+	return list
+}
+
+// ParseScheme finds a scheme by its canonical name or an alias. "default"
+// is DefaultScheme.
+func ParseScheme(s string) (Scheme, error) {
+	if s == "default" {
+		return DefaultScheme, nil
+	}
+	if info := findScheme(schemes, s); info != nil {
+		return info.ID, nil
+	}
+	return Scheme(-1), ErrInvalidScheme
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Info returns the scheme's descriptor, and whether it is registered.
+func (s Scheme) Info() (SchemeInfo, bool) {
+	for _, info := range schemes {
+		if info.ID == s {
+			return *info, true
+		}
+	}
+	return SchemeInfo{}, false
+}
+
+// the descriptor of a scheme, or ErrInvalidScheme
+func (s Scheme) info() (*SchemeInfo, error) {
+	for _, info := range schemes {
+		if info.ID == s {
+			return info, nil
+		}
+	}
+	return nil, ErrInvalidScheme
+}
+
+// The accessors below give the zero value for a scheme that is not
+// registered; everything that keys a cipher with one returns
+// ErrInvalidScheme instead.
+
+func (s Scheme) KeySize() int {
+	info, _ := s.Info()
+	return info.KeySize
+}
+
+// Size of the HMAC key. AEAD schemes only use it to derive the synthetic IV.
+func (s Scheme) MACSize() int {
+	info, _ := s.Info()
+	return info.MACSize
+}
+
+func (s Scheme) BlockSize() int {
+	info, _ := s.Info()
+	return info.BlockSize
+}
+
+// Size of the synthetic IV: a cipher block for CTR schemes, a nonce for AEADs
+func (s Scheme) IVSize() int {
+	info, _ := s.Info()
+	return info.IVSize
+}
+
+// Reports whether the scheme is an AEAD rather than a block cipher in CTR
+// mode with an HMAC
+func (s Scheme) AEAD() bool {
+	info, _ := s.Info()
+	return info.NewAEAD != nil
+}
+
+// Returns a cipher.Block of the relevant cipher
+func (s Scheme) NewCipher(key []byte) (cipher.Block, error) {
+	info, err := s.info()
+	if err != nil {
+		return nil, err
+	}
+	if info.NewCipher == nil {
+		return nil, fmt.Errorf("%s is not a CTR scheme", s)
+	}
+	return info.NewCipher(key)
+}
+
+// Returns a cipher.AEAD of the relevant construction
+func (s Scheme) NewAEAD(key []byte) (cipher.AEAD, error) {
+	info, err := s.info()
+	if err != nil {
+		return nil, err
+	}
+	if info.NewAEAD == nil {
+		return nil, fmt.Errorf("%s is not an AEAD scheme", s)
+	}
+	return info.NewAEAD(key)
+}
+
+// Returns '.New' of the relevant hash package
+func (s Scheme) Hash() func() hash.Hash {
+	info, _ := s.Info()
+	return info.Hash
+}
+
+func (s Scheme) String() string {
+	if info, ok := s.Info(); ok {
+		return info.Name
+	}
+	return fmt.Sprintf("Scheme(%d)", int(s))
+}
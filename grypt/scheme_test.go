@@ -0,0 +1,113 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchemes(t *testing.T) {
+	if s, err := ParseScheme("default"); err != nil || s != DefaultScheme {
+		t.Errorf("default: got %s, %v", s, err)
+	}
+	if _, err := ParseScheme("rot13"); err != ErrInvalidScheme {
+		t.Errorf("expected %q, got %v", ErrInvalidScheme, err)
+	}
+	for _, info := range Schemes() {
+		for _, name := range append([]string{info.Canonical}, info.Aliases...) {
+			if s, err := ParseScheme(name); err != nil || s != info.ID {
+				t.Errorf("%s: %q parsed as %s, %v", info.Name, name, s, err)
+			}
+		}
+		if info.ID.String() != info.Name {
+			t.Errorf("%s: printed as %s", info.Name, info.ID)
+		}
+		if info.Deprecated {
+			t.Errorf("%s: built in schemes, Blowfish included, are not deprecated", info.Name)
+		}
+		k, err := NewKey(bytes.NewReader(mkRand(SecretSize)), info.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk, err := k.subkeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newSealer(k, make([]byte, info.IVSize)); err != nil {
+			t.Errorf("%s: %v", info.Name, err)
+		}
+		if len(sk.Cipher) != info.KeySize || len(sk.MAC) != info.MACSize {
+			t.Errorf("%s: derived keys have the wrong size", info.Name)
+		}
+	}
+	if s := Scheme(-1).String(); s != "Scheme(-1)" {
+		t.Errorf("an unknown scheme printed as %s", s)
+	}
+
+	// an unknown scheme, as from a damaged or foreign key, is an error
+	// rather than a panic
+	if _, err := NewKey(bytes.NewReader(mkRand(SecretSize)), Scheme(-1)); err != ErrInvalidScheme {
+		t.Errorf("NewKey: expected %q, got %v", ErrInvalidScheme, err)
+	}
+	for _, v := range []int{KeyV1, KeyV2} {
+		k := Key{v, Scheme(-1), mkRand(SecretSize)}
+		if _, err := newSealer(k, make([]byte, 16)); err != ErrInvalidScheme {
+			t.Errorf("version %d sealer: expected %q, got %v", v, ErrInvalidScheme, err)
+		}
+		if err := Encrypt(bytes.NewReader(plaintext), ioutil.Discard, k); err != ErrInvalidScheme {
+			t.Errorf("version %d Encrypt: expected %q, got %v", v, ErrInvalidScheme, err)
+		}
+	}
+	if _, err := Scheme(-1).NewCipher(make([]byte, 32)); err != ErrInvalidScheme {
+		t.Errorf("NewCipher: expected %q, got %v", ErrInvalidScheme, err)
+	}
+}
+
+func TestRegisterScheme(t *testing.T) {
+	saved := schemes
+	defer func() { schemes = saved }()
+	info := SchemeInfo{
+		ID: 100, Name: "Test", Canonical: "test",
+		KeySize: 32, MACSize: 32, BlockSize: 16, IVSize: 16,
+		NewCipher: AES256_SHA256.NewCipher, Hash: sha256.New,
+		Deprecated: true,
+	}
+	RegisterScheme(info)
+	if s, err := ParseScheme("test"); err != nil || s != 100 {
+		t.Fatalf("registered scheme parsed as %s, %v", s, err)
+	}
+	if got, _ := Scheme(100).Info(); !got.Deprecated {
+		t.Error("the registry lost the scheme's deprecation")
+	}
+	k, _ := NewKey(bytes.NewReader(mkRand(SecretSize)), 100)
+	ct, x := new(bytes.Buffer), new(bytes.Buffer)
+	if err := Encrypt(bytes.NewReader(plaintext), ct, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decrypt(bytes.NewReader(ct.Bytes()), x, k); err != nil || !bytes.Equal(x.Bytes(), plaintext) {
+		t.Errorf("round trip with a registered scheme failed: %v", err)
+	}
+
+	for name, bad := range map[string]SchemeInfo{
+		"taken ID":       info,
+		"taken name":     {ID: 101, Name: "Other", Canonical: "keccak", KeySize: 32, MACSize: 32, IVSize: 16, NewCipher: info.NewCipher, Hash: sha256.New},
+		"default":        {ID: 101, Name: "Other", Canonical: "default", KeySize: 32, MACSize: 32, IVSize: 16, NewCipher: info.NewCipher, Hash: sha256.New},
+		"no constructor": {ID: 101, Name: "Other", Canonical: "other", KeySize: 32, MACSize: 32, IVSize: 16, Hash: sha256.New},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: registered", name)
+				}
+			}()
+			RegisterScheme(bad)
+		}()
+	}
+
+	// without it, files in that scheme are refused before any key is tried
+	schemes = saved
+	if err := Decrypt(ct, ioutil.Discard, keys[0]); err != ErrInvalidScheme {
+		t.Errorf("expected %q, got %v", ErrInvalidScheme, err)
+	}
+}
@@ -0,0 +1,202 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Version field of a share of a key ring, numbered along with the key
+// versions like KeyLocked.
+const KeyShare = 5
+
+var (
+	// A share does not match its checksum.
+	ErrBadShare = errors.New("share is corrupted")
+	// Too few shares were given to rebuild the keys.
+	ErrTooFewShares = errors.New("not enough shares")
+)
+
+// Share is one of the pieces SplitKeyRing cuts a key ring into, with
+// Shamir's secret sharing over GF(2^8): any Threshold of them give back the
+// ring, fewer tell nothing about it.
+type Share struct {
+	Version int
+	// Scheme and ID of the ring's first key, so shares of different keys
+	// are not mixed up
+	Scheme    Scheme
+	KeyID     []byte
+	Threshold int
+	// Where the polynomials were evaluated, from 1, and what they gave
+	X int
+	Y []byte
+	// The first bytes of a SHA-256 over the rest of the share
+	Sum []byte
+}
+
+// SplitKeyRing cuts the serialized keys of 'ring' into 'n' shares, any
+// 'threshold' of which rebuild it. The polynomials' coefficients are read
+// from r.
+func SplitKeyRing(r io.Reader, ring KeyRing, n, threshold int) ([]Share, error) {
+	if threshold < 2 || n < threshold || n > 255 {
+		return nil, fmt.Errorf("can not make %d shares needing %d of them", n, threshold)
+	}
+	if len(ring) == 0 {
+		return nil, ErrNoKeys
+	}
+	var secret []byte
+	for _, k := range ring {
+		bits, err := MarshalKey(k)
+		if err != nil {
+			return nil, err
+		}
+		secret = append(secret, bits...)
+	}
+	// one polynomial per byte of the secret, which is its constant term
+	coef := make([]byte, len(secret)*(threshold-1))
+	if _, err := io.ReadFull(r, coef); err != nil {
+		return nil, err
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		s := Share{KeyShare, ring[0].Scheme, ring[0].ID(), threshold, i + 1, make([]byte, len(secret)), nil}
+		x := byte(s.X)
+		for j, b := range secret {
+			// Horner, from the highest coefficient down
+			y := byte(0)
+			for c := threshold - 2; c >= 0; c-- {
+				y = gfMul(y, x) ^ coef[j*(threshold-1)+c]
+			}
+			s.Y[j] = gfMul(y, x) ^ b
+		}
+		s.Sum = s.sum()
+		shares[i] = s
+	}
+	return shares, nil
+}
+
+// CombineShares rebuilds the key ring from at least Threshold of its shares.
+func CombineShares(shares []Share) (KeyRing, error) {
+	if len(shares) == 0 {
+		return nil, ErrTooFewShares
+	}
+	first := shares[0]
+	// one of each, as the same share may be given twice
+	var use []Share
+	seen := map[int]bool{}
+	for _, s := range shares {
+		if !bytes.Equal(s.Sum, s.sum()) {
+			return nil, ErrBadShare
+		}
+		if s.Scheme != first.Scheme || !bytes.Equal(s.KeyID, first.KeyID) ||
+			s.Threshold != first.Threshold || len(s.Y) != len(first.Y) {
+			return nil, fmt.Errorf("shares of different keys: %x and %x", first.KeyID, s.KeyID)
+		}
+		if !seen[s.X] && len(use) < s.Threshold {
+			seen[s.X] = true
+			use = append(use, s)
+		}
+	}
+	if first.Threshold < 2 || len(use) < first.Threshold {
+		return nil, ErrTooFewShares
+	}
+
+	// Lagrange interpolation at 0, where addition and subtraction are both
+	// XOR
+	secret := make([]byte, len(first.Y))
+	for _, s := range use {
+		basis := byte(1)
+		for _, o := range use {
+			if o.X != s.X {
+				basis = gfMul(basis, gfMul(byte(o.X), gfInv(byte(o.X^s.X))))
+			}
+		}
+		for j, y := range s.Y {
+			secret[j] ^= gfMul(y, basis)
+		}
+	}
+
+	var ring KeyRing
+	for rest := secret; len(rest) > 0; {
+		var raw asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+			return nil, err
+		}
+		k, err := UnmarshalKey(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		ring = append(ring, k)
+	}
+	if len(ring) == 0 || !bytes.Equal(ring[0].ID(), first.KeyID) {
+		return nil, fmt.Errorf("shares did not combine into key %x", first.KeyID)
+	}
+	return ring, nil
+}
+
+func (s Share) sum() []byte {
+	s.Sum = nil
+	der, err := asn1.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(der)
+	return sum[:8]
+}
+
+// MarshalShare base64 encodes a share on one line, like a key file.
+func MarshalShare(s Share) ([]byte, error) {
+	der, err := asn1.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(der) + "\n"), nil
+}
+
+// UnmarshalShare decodes a share written by MarshalShare, and checks it
+// against its checksum.
+func UnmarshalShare(bits []byte) (Share, error) {
+	var s Share
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(bits)))
+	if err != nil {
+		return s, err
+	}
+	if _, err = asn1.Unmarshal(der, &s); err != nil || s.Version != KeyShare {
+		return s, fmt.Errorf("not a share of a key")
+	}
+	if _, ok := s.Scheme.Info(); !ok {
+		return s, ErrInvalidScheme
+	}
+	if s.X < 1 || s.X > 255 || !bytes.Equal(s.Sum, s.sum()) {
+		return s, ErrBadShare
+	}
+	return s, nil
+}
+
+// multiplication in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1
+func gfMul(x, y byte) byte {
+	var z byte
+	for y != 0 {
+		if y&1 != 0 {
+			z ^= x
+		}
+		x = x<<1 ^ -(x>>7)&0x1B
+		y >>= 1
+	}
+	return z
+}
+
+// the inverse of x, which is x^254; 0 has none
+func gfInv(x byte) byte {
+	z := byte(1)
+	for i := 0; i < 254; i++ {
+		z = gfMul(z, x)
+	}
+	return z
+}
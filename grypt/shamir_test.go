@@ -0,0 +1,79 @@
+package grypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestShares(t *testing.T) {
+	ring := KeyRing{keys[0], keys[len(keys)-1]}
+	shares, err := SplitKeyRing(rand.Reader, ring, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pick := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4, 0}, {3, 3, 1, 4}} {
+		var some []Share
+		for _, n := range pick {
+			bits, err := MarshalShare(shares[n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := UnmarshalShare(bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			some = append(some, s)
+		}
+		got, err := CombineShares(some)
+		if err != nil {
+			t.Fatalf("shares %v: %v", pick, err)
+		}
+		if len(got) != len(ring) || !bytes.Equal(got[0].Secret, ring[0].Secret) || !bytes.Equal(got[1].Secret, ring[1].Secret) {
+			t.Errorf("shares %v did not combine into the keys they were made from", pick)
+		}
+	}
+
+	if _, err = CombineShares(shares[1:2]); err != ErrTooFewShares {
+		t.Errorf("expected %q, got %v", ErrTooFewShares, err)
+	}
+	if _, err = CombineShares([]Share{shares[2], shares[2], shares[2]}); err != ErrTooFewShares {
+		t.Errorf("expected %q, got %v", ErrTooFewShares, err)
+	}
+
+	// a corrupted share is caught before it is used
+	bad := shares[1]
+	bad.Y = append([]byte(nil), bad.Y...)
+	bad.Y[3] ^= 1
+	bits, err := MarshalShare(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalShare(bits); err != ErrBadShare {
+		t.Errorf("expected %q, got %v", ErrBadShare, err)
+	}
+	if _, err = CombineShares([]Share{shares[0], bad, shares[2]}); err != ErrBadShare {
+		t.Errorf("expected %q, got %v", ErrBadShare, err)
+	}
+
+	// shares of the same keys, split twice, do not mix
+	other, err := SplitKeyRing(rand.Reader, ring, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CombineShares([]Share{shares[0], other[1], shares[2]}); err == nil {
+		t.Error("shares of two splits combined")
+	}
+}
+
+func TestGF256(t *testing.T) {
+	for x := 1; x < 256; x++ {
+		if p := gfMul(byte(x), gfInv(byte(x))); p != 1 {
+			t.Fatalf("%d times its inverse is %d", x, p)
+		}
+	}
+	// the example from FIPS 197
+	if p := gfMul(0x57, 0x83); p != 0xc1 {
+		t.Errorf("expected 0x57 * 0x83 = 0xc1, got %#x", p)
+	}
+}
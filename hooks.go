@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Marks a hook written by install-hooks
+const hookMarker = "# installed by grypt install-hooks"
+
+// An existing pre-commit hook is moved here and run before ours
+const chainedHook = "pre-commit.before-grypt"
+
+// write a pre-commit hook that runs `grypt pre-commit', keeping any hook
+// that was already there
+func installHooks() error {
+	out, err := git("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(strings.TrimSpace(string(out)))
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	hook := filepath.Join(dir, "pre-commit")
+	old, err := ioutil.ReadFile(hook)
+	if err == nil && !bytes.Contains(old, []byte(hookMarker)) {
+		chained := filepath.Join(dir, chainedHook)
+		if _, err := os.Stat(chained); err == nil {
+			return fmt.Errorf("both %s and %s exist, merge them by hand", hook, chained)
+		}
+		if err = os.Rename(hook, chained); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "moved the existing hook to %s; it still runs first\n", chained)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+%s
+hook="$(dirname "$0")/%s"
+if [ -x "$hook" ]; then
+	"$hook" "$@" || exit $?
+fi
+exec %s pre-commit
+`, hookMarker, chainedHook, shellQuote(portableExe()))
+	return ioutil.WriteFile(hook, []byte(script), 0755)
+}
+
+// quote s for sh, unless it is plain enough already
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_./-") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// refuse a commit whose index holds a file meant for grypt in the clear, as
+// happens when a clone lacks the filter config, or with a damaged header
+func preCommit() error {
+	if err := chdirTop(); err != nil {
+		return err
+	}
+	entries, err := lsFiles()
+	if err != nil {
+		return err
+	}
+	blobs, err := classifyBlobs(entries, nil)
+	if err != nil {
+		return err
+	}
+	var plain []string
+	for p, b := range blobs {
+		if b.Kind == blobPlaintext || b.Kind == blobInvalid {
+			plain = append(plain, p)
+		}
+	}
+	sort.Strings(plain)
+	if len(plain) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(os.Stderr, "grypt: these files are meant to be encrypted, but are staged without it:")
+	for _, p := range plain {
+		fmt.Fprintf(os.Stderr, "\t%s\n", p)
+	}
+	if _, err := git("config", "filter.grypt.clean"); err != nil {
+		fmt.Fprintln(os.Stderr, "\nThe grypt filter is not set up in this clone. Run `grypt init KEYFILE',\nthen `git add --renormalize' the files above and commit again.")
+	} else {
+		fmt.Fprintln(os.Stderr, "\nRe-add them with `git add --renormalize' and commit again.")
+	}
+	return fmt.Errorf("commit refused")
+}
@@ -0,0 +1,102 @@
+// Package stream holds the stream handling shared by the grypt command and
+// the grypt package.
+package stream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrMalformed is returned by ReadDER for a length that is not understood
+// or is over the limit.
+var ErrMalformed = errors.New("malformed DER value")
+
+// ReadDER reads exactly one DER encoded value from r, of at most max bytes
+// past its tag and length.
+func ReadDER(r io.Reader, max int) ([]byte, error) {
+	// tag and the first length byte
+	bits := make([]byte, 2, 64)
+	if _, err := io.ReadFull(r, bits); err != nil {
+		return nil, err
+	}
+	length := int(bits[1])
+	if length&0x80 != 0 {
+		// long form: the low bits count the length bytes that follow
+		n := length & 0x7f
+		if n == 0 || n > 3 {
+			return nil, ErrMalformed
+		}
+		lengthBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+		bits = append(bits, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > max {
+		return nil, ErrMalformed
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(bits, body...), nil
+}
+
+// Spool copies all of r into w and returns a reader that replays the same
+// bytes afterwards. Up to 'memory' bytes are kept in memory; anything
+// larger goes to a temporary file, enciphered under a throwaway key so
+// plaintext never touches the disk; replay is a *bytes.Buffer when it did
+// not come to that. done releases the temporary file.
+func Spool(r io.Reader, w io.Writer, memory int64) (replay io.Reader, done func(), err error) {
+	head := new(bytes.Buffer)
+	_, err = io.CopyN(io.MultiWriter(head, w), r, memory)
+	if err == io.EOF {
+		return head, func() {}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	f, err := ioutil.TempFile("", "grypt")
+	if err != nil {
+		return nil, nil, err
+	}
+	done = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	sw := cipher.StreamWriter{
+		S: cipher.NewCTR(c, iv),
+		W: f,
+	}
+	if _, err = head.WriteTo(sw); err == nil {
+		_, err = io.Copy(io.MultiWriter(sw, w), r)
+	}
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	return cipher.StreamReader{S: cipher.NewCTR(c, iv), R: f}, done, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// File at the top of the work tree recording how `phrase' turns a
+// passphrase into a key. It holds no secrets and should be committed, so
+// the same passphrase gives the same key in every clone.
+const PhraseFile = ".grypt-phrase"
+
+// read the parameters in file 'f', or create it with new ones if it does
+// not exist. Reports whether the file was created.
+func loadKDFParams(f string, n, r, p int) (grypt.KDFParams, bool, error) {
+	bits, err := ioutil.ReadFile(f)
+	if err == nil {
+		kp, err := grypt.ParseKDFParams(string(bits))
+		return kp, false, err
+	}
+	if !os.IsNotExist(err) {
+		return grypt.KDFParams{}, false, err
+	}
+	kp, err := grypt.NewKDFParams(rand.Reader, n, r, p)
+	if err != nil {
+		return kp, false, err
+	}
+	return kp, true, ioutil.WriteFile(f, []byte(kp.String()+"\n"), 0644)
+}
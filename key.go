@@ -2,201 +2,113 @@ package main
 
 import (
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/sha256"
-	"encoding/asn1"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
-	"hash"
-	"io"
 	"os"
+	"path/filepath"
 
-	"code.google.com/p/go.crypto/blowfish"
-	"code.google.com/p/go.crypto/sha3"
-	"polydawn.net/grypt/ext/blake2b"
+	"polydawn.net/grypt/grypt"
 )
 
-const (
-	// Use AES-256 with a SHA-256 HMAC
-	AES256_SHA256 Scheme = iota
-	// Use AES-256 with a Keccak-256 (SHA3) HMAC
-	AES256_Keccak256
-	// Use Blowfish-448 with a SHA-256 HMAC
-	Blowfish448_SHA256
-	// Use AES-265 with a BLAKE2-256 HMAC
-	AES256_BLAKE2256
-	// Use Blowfish-448 with a BLAKE2-512 HMAC
-	Blowfish448_BLAKE2512
-)
-
-var (
-	// Scheme used for new keys.
-	DefaultScheme = AES256_SHA256
-	// The scheme indicated does not exist or is not supported.
-	ErrInvalidScheme = fmt.Errorf("invalid scheme")
-)
-
-type (
-	// Key is used for symmetric encryption in/out of the blob store
-	Key struct {
-		Scheme Scheme
-		// Symetric cipher key
-		Key []byte
-		// Key for HMAC
-		HMAC []byte
-	}
-	// Encryption scheme
-	Scheme int
-)
-
-func ParseScheme(s string) (Scheme, error) {
-	switch s {
-	case "default", "aes256sha256":
-		return AES256_SHA256, nil
-	case "keccak", "aes256keccak256":
-		return AES256_Keccak256, nil
-	case "blowfish", "blowfish448sha256":
-		return Blowfish448_SHA256, nil
-	case "blake2", "aes256blake2256":
-		return AES256_BLAKE2256, nil
-	case "blakefish", "blowfish448blake2512":
-		return Blowfish448_BLAKE2512, nil
-	}
-	return Scheme(-1), ErrInvalidScheme
-}
-
-func (s Scheme) KeySize() int {
-	switch s {
-	case AES256_SHA256, AES256_Keccak256, AES256_BLAKE2256:
-		return 32
-	case Blowfish448_SHA256, Blowfish448_BLAKE2512:
-		return 56
-	default:
-		panic("invalid Scheme")
+// base64 encode and write key 'k' to file 'f'
+func WriteKey(f string, k grypt.Key) error {
+	bits, err := grypt.MarshalKey(k)
+	if err != nil {
+		return err
 	}
-}
-
-func (s Scheme) MACSize() int {
-	switch s {
-	case Blowfish448_SHA256, AES256_SHA256, AES256_Keccak256, AES256_BLAKE2256:
-		return 32
-	case Blowfish448_BLAKE2512:
-		return 64
-	default:
-		panic("invalid Scheme")
+	if isKeySource(f) {
+		return fmt.Errorf("%s is not a file and can not be written", f)
 	}
-}
-
-func (s Scheme) BlockSize() int {
-	switch s {
-	case AES256_SHA256, AES256_Keccak256, AES256_BLAKE2256:
-		return aes.BlockSize
-	case Blowfish448_SHA256, Blowfish448_BLAKE2512:
-		return blowfish.BlockSize
-	default:
-		panic("invalid Scheme")
+	if err = os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+		return err
 	}
-}
-
-// Returns a cipher.Block of the relevant cipher
-func (s Scheme) NewCipher(key []byte) (cipher.Block, error) {
-	switch s {
-	case AES256_SHA256, AES256_Keccak256, AES256_BLAKE2256:
-		return aes.NewCipher(key)
-	case Blowfish448_SHA256, Blowfish448_BLAKE2512:
-		return blowfish.NewCipher(key)
-	default:
-		panic("invalid Scheme")
+	file, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
 	}
-}
-
-// Returns '.New' of the relevant hash package
-func (s Scheme) Hash() func() hash.Hash {
-	switch s {
-	case Blowfish448_SHA256, AES256_SHA256:
-		return sha256.New
-	case AES256_Keccak256:
-		return sha3.NewKeccak256
-	case AES256_BLAKE2256:
-		return blake2b.New256
-	case Blowfish448_BLAKE2512:
-		return blake2b.New512
-	default:
-		panic("invalid Scheme")
+	defer file.Close()
+	enc := base64.NewEncoder(base64.StdEncoding, file)
+	_, err = bytes.NewBuffer(bits).WriteTo(enc)
+	if err != nil {
+		return err
 	}
+	enc.Close()
+	return nil
 }
 
-func (s Scheme) String() string {
-	switch s {
-	case Blowfish448_SHA256:
-		return "Blowfish-448/SHA-256"
-	case AES256_SHA256:
-		return "AES-256/SHA-256"
-	case AES256_Keccak256:
-		return "AES-256/Keccak-256"
-	case AES256_BLAKE2256:
-		return "AES-256/BLAKE2-256"
-	case Blowfish448_BLAKE2512:
-		return "Blowfish-448/BLAKE2-512"
-	default:
-		panic("invalid Scheme")
+// read and decode a key from file 'f'. If 'f' is a key ring, its first key
+// is returned.
+func ReadKey(f string) (grypt.Key, error) {
+	ring, err := ReadKeyRing(f)
+	if err != nil {
+		return grypt.Key{}, err
 	}
+	return ring[0], nil
 }
 
-// return a Key from the supplied Reader
-func NewKey(r io.Reader, s Scheme) (Key, error) {
-	var err error
-	symKey := make([]byte, s.KeySize())
-	macKey := make([]byte, s.MACSize())
-	_, err = io.ReadFull(r, macKey)
+// write the keys in 'ring' to file 'f'. If 'f' is locked it stays locked
+// under the same passphrase.
+func WriteKeyRing(f string, ring grypt.KeyRing) error {
+	bits, err := grypt.MarshalKeyRing(ring)
 	if err != nil {
-		return Key{}, err
-	}
-	_, err = io.ReadFull(r, symKey)
-	if err != nil {
-		return Key{}, err
+		return err
 	}
-	return Key{s, symKey, macKey}, nil
+	if kp, ok := keyFileLocked(f); ok {
+		phrase, err := passphrase(f, false)
+		if err != nil {
+			return err
+		}
+		if kp, err = grypt.NewKDFParams(rand.Reader, kp.N, kp.R, kp.P); err != nil {
+			return err
+		}
+		if bits, err = grypt.LockKeyRing(rand.Reader, ring, phrase, kp); err != nil {
+			return err
+		}
+	}
+	return writeKeyFile(f, bits)
 }
 
-// base64 encode and write key 'k' to file 'f'
-func WriteKey(f string, k Key) error {
-	bits, err := asn1.Marshal(k)
+// write the keys from 'read' into keyfile, then list them. keyfile must be
+// a file that does not exist yet, which is checked before 'read' asks for
+// anything.
+func writeNewKeyFile(read func() (grypt.KeyRing, error)) error {
+	if keyArg == "" {
+		return fmt.Errorf("no key file given")
+	}
+	if isKeySource(keyfile) {
+		return fmt.Errorf("%s is not a file and can not be written", keyfile)
+	}
+	if _, err := os.Stat(keyfile); err == nil {
+		return fmt.Errorf("%s already exists", keyfile)
+	}
+	ring, err := read()
 	if err != nil {
 		return err
 	}
-	file, err := os.Create(f)
-	if err != nil {
+	if err = os.MkdirAll(filepath.Dir(keyfile), 0700); err != nil {
 		return err
 	}
-	defer file.Close()
-	enc := base64.NewEncoder(base64.StdEncoding, file)
-	_, err = bytes.NewBuffer(bits).WriteTo(enc)
-	if err != nil {
+	if err = WriteKeyRing(keyfile, ring); err != nil {
 		return err
 	}
-	enc.Close()
-	return nil
+	return listKeys()
 }
 
-// read and decode a key from file 'f'
-func ReadKey(f string) (Key, error) {
-	k := Key{}
-	bits := new(bytes.Buffer)
-	file, err := os.Open(f)
+// read and decode the keys in file 'f'. A file written by WriteKey is a
+// ring of one. A locked file is unlocked by the agent or
+// its passphrase.
+func ReadKeyRing(f string) (grypt.KeyRing, error) {
+	bits, err := readKeyFile(f)
 	if err != nil {
-		return Key{}, err
+		return nil, err
 	}
-	defer file.Close()
-	dec := base64.NewDecoder(base64.StdEncoding, file)
-	_, err = bits.ReadFrom(dec)
-	if err != nil {
-		return Key{}, err
+	ring, err := grypt.ParseKeyRing(bits)
+	if err == grypt.ErrLocked {
+		return unlockKeyFile(f, bits)
 	}
-	_, err = asn1.Unmarshal(bits.Bytes(), &k)
-	if err != nil {
-		return Key{}, err
+	if err == grypt.ErrNoKeys {
+		return nil, fmt.Errorf("%s: %v", f, err)
 	}
-	return k, nil
+	return ring, err
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"polydawn.net/grypt/grypt"
+)
+
+var (
+	plaintext = mkRand(1024)
+	keys      = []grypt.Key{
+		{Version: grypt.KeyV2, Scheme: grypt.AES256_SHA256, Secret: mkRand(grypt.SecretSize)},
+		{Version: grypt.KeyV2, Scheme: grypt.AES256_Keccak256, Secret: mkRand(grypt.SecretSize)},
+		{Version: grypt.KeyV2, Scheme: grypt.XChaCha20_Poly1305, Secret: mkRand(grypt.SecretSize)},
+		{Version: grypt.KeyV1, Scheme: grypt.AES256_SHA256, Secret: mkRand(grypt.AES256_SHA256.MACSize() + grypt.AES256_SHA256.KeySize())},
+	}
+)
+
+func mkRand(sz int) []byte {
+	k := make([]byte, sz)
+	io.ReadFull(rand.Reader, k)
+	return k
+}
+
+func tempKeyfile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "grypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "key"), func() { os.RemoveAll(dir) }
+}
+
+func TestKeyFile(t *testing.T) {
+	f, done := tempKeyfile(t)
+	defer done()
+	for _, k := range keys {
+		if err := WriteKey(f, k); err != nil {
+			t.Fatal(err)
+		}
+		k2, err := ReadKey(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k2.Version != k.Version || k2.Scheme != k.Scheme || !bytes.Equal(k2.Secret, k.Secret) {
+			t.Errorf("%s: key changed after a round trip through %s", k.Scheme, f)
+		}
+	}
+}
+
+func TestKeyRingFile(t *testing.T) {
+	f, done := tempKeyfile(t)
+	defer done()
+	ring := grypt.KeyRing{keys[0], keys[len(keys)-1], keys[1]}
+	if err := WriteKeyRing(f, ring); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadKeyRing(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(ring) {
+		t.Fatalf("expected %d keys, got %d", len(ring), len(read))
+	}
+	for n := range ring {
+		if !bytes.Equal(read[n].Secret, ring[n].Secret) || read[n].Version != ring[n].Version {
+			t.Errorf("key %d changed after a round trip", n)
+		}
+	}
+	if k, err := ReadKey(f); err != nil || !bytes.Equal(k.Secret, ring[0].Secret) {
+		t.Errorf("ReadKey did not return the first key of the ring")
+	}
+
+	if err = ioutil.WriteFile(f, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadKeyRing(f); err == nil {
+		t.Errorf("an empty key file was read")
+	}
+}
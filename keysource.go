@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Key locations that are not files, for places where the key should not
+// touch the disk. They are accepted wherever a KEYFILE is:
+//
+//	env:NAME      the value of environment variable NAME
+//	fd:N          everything read from open file descriptor N
+//	cmd:COMMAND   the output of COMMAND, run by sh
+//
+// Each is read at most once per run.
+var keySources = []string{"env:", "fd:", "cmd:"}
+
+// key sources already read this run
+var sourcedKeys = map[string][]byte{}
+
+func isKeySource(loc string) bool {
+	for _, p := range keySources {
+		if strings.HasPrefix(loc, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// read the key file or key source 'loc'
+func readKeyFile(loc string) ([]byte, error) {
+	if !isKeySource(loc) {
+		return ioutil.ReadFile(loc)
+	}
+	if bits, ok := sourcedKeys[loc]; ok {
+		return bits, nil
+	}
+	bits, err := readKeySource(loc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", loc, err)
+	}
+	sourcedKeys[loc] = bits
+	return bits, nil
+}
+
+func readKeySource(loc string) ([]byte, error) {
+	kind, arg := loc[:strings.IndexByte(loc, ':')], loc[strings.IndexByte(loc, ':')+1:]
+	switch kind {
+	case "env":
+		v := os.Getenv(arg)
+		if v == "" {
+			return nil, fmt.Errorf("$%s is not set", arg)
+		}
+		return []byte(v), nil
+	case "fd":
+		fd, err := strconv.Atoi(arg)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("bad file descriptor %q", arg)
+		}
+		f := os.NewFile(uintptr(fd), loc)
+		if f == nil {
+			return nil, fmt.Errorf("bad file descriptor %q", arg)
+		}
+		defer f.Close()
+		return ioutil.ReadAll(f)
+	case "cmd":
+		// stdin is left alone, as the filters read the file from it
+		c := exec.Command("sh", "-c", arg)
+		out := new(bytes.Buffer)
+		c.Stdout, c.Stderr = out, os.Stderr
+		if err := c.Run(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown key source")
+}
+
+// write key file 'f', refusing key sources
+func writeKeyFile(f string, bits []byte) error {
+	if isKeySource(f) {
+		return fmt.Errorf("%s is not a file and can not be written", f)
+	}
+	return ioutil.WriteFile(f, bits, 0600)
+}
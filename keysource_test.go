@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"polydawn.net/grypt/grypt"
+)
+
+func TestKeySources(t *testing.T) {
+	f, done := tempKeyfile(t)
+	defer done()
+	if err := WriteKeyRing(f, grypt.KeyRing{keys[0], keys[1]}); err != nil {
+		t.Fatal(err)
+	}
+	bits, err := readKeyFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Setenv("GRYPT_TEST_KEY", os.Getenv("GRYPT_TEST_KEY"))
+	os.Setenv("GRYPT_TEST_KEY", string(bits))
+	for _, src := range []string{"env:GRYPT_TEST_KEY", "cmd:cat '" + f + "'", "fd:" + keyFD(t, bits)} {
+		for n := 0; n < 2; n++ {
+			ring, err := ReadKeyRing(src)
+			if err != nil {
+				t.Fatalf("%s: %v", src, err)
+			}
+			if len(ring) != 2 || !bytes.Equal(ring[1].Secret, keys[1].Secret) {
+				t.Errorf("%s: wrong keys", src)
+			}
+		}
+		if err = WriteKeyRing(src, grypt.KeyRing{keys[2]}); err == nil {
+			t.Errorf("%s: wrote a key source", src)
+		}
+	}
+	for _, src := range []string{"env:GRYPT_TEST_UNSET", "cmd:false", "fd:x"} {
+		if _, err := ReadKeyRing(src); err == nil {
+			t.Errorf("%s: read a key", src)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// a file descriptor that reads 'bits', for the fd: key source to own
+func keyFD(t *testing.T, bits []byte) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(bits)
+		w.Close()
+	}()
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.Itoa(fd)
+}
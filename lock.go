@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// Environment variable consulted for the passphrase of a locked key file
+// before prompting, for use where there is no terminal.
+const PassphraseEnv = "GRYPT_PASSPHRASE"
+
+// passphrases already given this run, by key file
+var passphrases = map[string][]byte{}
+
+// reports whether key file 'f' exists and is locked, and how
+func keyFileLocked(f string) (grypt.KDFParams, bool) {
+	bits, err := ioutil.ReadFile(f)
+	if err != nil {
+		return grypt.KDFParams{}, false
+	}
+	return grypt.LockedKDFParams(bits)
+}
+
+// find the passphrase for key file 'f': one already given this run, then
+// PassphraseEnv, then the terminal.
+func passphrase(f string, confirm bool) ([]byte, error) {
+	if abs, err := filepath.Abs(f); err == nil {
+		f = abs
+	}
+	if p, ok := passphrases[f]; ok {
+		return p, nil
+	}
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		passphrases[f] = []byte(p)
+		return []byte(p), nil
+	}
+	p, err := readPhrase(fmt.Sprintf("passphrase for %s: ", filepath.Base(f)))
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		again, err := readPhrase("again: ")
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(p) {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	passphrases[f] = p
+	return p, nil
+}
+
+// unlock the key file 'f', whose contents are 'locked'. A running agent
+// is asked first, and is given the keys once the passphrase opens them.
+func unlockKeyFile(f string, locked []byte) (grypt.KeyRing, error) {
+	if abs, err := filepath.Abs(f); err == nil {
+		f = abs
+	}
+	if plain, ok := agentKeys(f, locked); ok {
+		return grypt.ParseKeyRing(plain)
+	}
+	phrase, err := passphrase(f, false)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := grypt.UnlockKeyRing(locked, phrase)
+	if err == grypt.ErrBadPassphrase {
+		delete(passphrases, f)
+		return nil, fmt.Errorf("%s: %v", f, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plain, err := grypt.MarshalKeyRing(ring); err == nil {
+		agentStore(f, locked, plain)
+	}
+	return ring, nil
+}
+
+// seal the key ring in file 'f' under 'phrase'
+func lockKeyFile(f string, phrase []byte, kp grypt.KDFParams) error {
+	ring, err := ReadKeyRing(f)
+	if err != nil {
+		return err
+	}
+	bits, err := grypt.LockKeyRing(rand.Reader, ring, phrase, kp)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(f, bits)
+}
+
+func lock() error {
+	if isKeySource(keyfile) {
+		return fmt.Errorf("%s is not a file and can not be locked", keyfile)
+	}
+	if _, ok := keyFileLocked(keyfile); ok {
+		return fmt.Errorf("%s is already locked", keyfile)
+	}
+	phrase, err := passphrase(keyfile, true)
+	if err != nil {
+		return err
+	}
+	kp, err := grypt.NewKDFParams(rand.Reader, *scryptN, *scryptR, *scryptP)
+	if err != nil {
+		return err
+	}
+	return lockKeyFile(keyfile, phrase, kp)
+}
+
+func unlock() error {
+	if _, ok := keyFileLocked(keyfile); !ok {
+		return fmt.Errorf("%s is not locked", keyfile)
+	}
+	ring, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return err
+	}
+	plain, err := grypt.MarshalKeyRing(ring)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(keyfile, plain)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"polydawn.net/grypt/grypt"
+)
+
+func TestLockedKeyFile(t *testing.T) {
+	f, done := tempKeyfile(t)
+	defer done()
+	defer os.Setenv(PassphraseEnv, os.Getenv(PassphraseEnv))
+	forget := func() {
+		passphrases = map[string][]byte{}
+	}
+	defer forget()
+
+	ring := grypt.KeyRing{keys[0], keys[1]}
+	if err := WriteKeyRing(f, ring); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := grypt.NewKDFParams(rand.Reader, 1<<10, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lockKeyFile(f, []byte("hunter2"), kp); err != nil {
+		t.Fatal(err)
+	}
+	bits, _ := ioutil.ReadFile(f)
+	if _, ok := grypt.LockedKDFParams(bits); !ok {
+		t.Fatal("key file is not locked")
+	}
+	plain, _ := grypt.MarshalKeyRing(ring)
+	for _, line := range strings.Fields(string(plain)) {
+		if strings.Contains(string(bits), line) {
+			t.Fatal("locked key file contains the key")
+		}
+	}
+
+	forget()
+	os.Setenv(PassphraseEnv, "hunter3")
+	if _, err = ReadKeyRing(f); err == nil {
+		t.Fatal("locked key file opened with the wrong passphrase")
+	}
+
+	forget()
+	os.Setenv(PassphraseEnv, "hunter2")
+	read, err := ReadKeyRing(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(ring) || !bytes.Equal(read[1].Secret, ring[1].Secret) {
+		t.Fatal("keys changed after locking")
+	}
+
+	// rewriting a locked ring keeps it locked
+	if err = WriteKeyRing(f, append(read, keys[2])); err != nil {
+		t.Fatal(err)
+	}
+	forget()
+	if _, ok := keyFileLocked(f); !ok {
+		t.Fatal("key file was unlocked by WriteKeyRing")
+	}
+	if read, err = ReadKeyRing(f); err != nil || len(read) != 3 {
+		t.Fatalf("rewritten key file: %d keys, %v", len(read), err)
+	}
+}
@@ -1,101 +1,212 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
-	"crypto/sha256"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
-	"code.google.com/p/go.crypto/hkdf"
+	"polydawn.net/grypt/grypt"
 )
 
 var (
 	keyfile string
-	exe     string
+	// keyfile as it was given, before resolveKeyfile
+	keyArg string
+	// the subcommand and its arguments, without any options
+	args []string
 
-	iterations     = 4096
-	attributesHelp = `Edit your .gitattributes if it's not configured already:
-
-	secretfile filter=grypt diff=grypt
-	*.secret filter=grypt diff=grypt
+	attributesHelp = "Run `grypt track PATTERN' for each kind of file to encrypt, or edit your\n" +
+		".gitattributes if it's not configured already:\n" + `
+	secretfile filter=grypt diff=grypt merge=grypt
+	*.secret filter=grypt diff=grypt merge=grypt
 `
-	encryptionScheme Scheme
+	encryptionScheme grypt.Scheme
 	schemeString     = flag.String("t", "default", "Which encryption scheme to use (only applicable to 'phrase' and 'keygen')")
-)
-
-type (
-	// Header contains information about the encryption scheme
-	Header struct {
-		Scheme Scheme
-		IV     []byte
-		MAC    []byte
-	}
+	scryptN          = flag.Int("N", 1<<17, "scrypt CPU/memory cost for 'lock', and for 'phrase' when creating "+PhraseFile)
+	scryptR          = flag.Int("r", 8, "scrypt block size for 'lock', and for 'phrase' when creating "+PhraseFile)
+	scryptP          = flag.Int("p", 1, "scrypt parallelism for 'lock', and for 'phrase' when creating "+PhraseFile)
+	cacheTextconv    = flag.Bool("cachetextconv", false, "Have 'init' let git cache decrypted files for diffs, in the clear under refs/notes/textconv/grypt")
+	uninitCheckout   = flag.Bool("checkout", false, "Have 'uninit' replace the plaintext in the work tree with ciphertext")
+	jsonOutput       = flag.Bool("json", false, "Print 'status' as JSON")
+	agentTimeout     = flag.Duration("timeout", 15*time.Minute, "How long 'agent' holds an unlocked key")
+	keyFlag          = flag.String("key", "", "Key file for 'encrypt', 'decrypt' and 'cat', instead of the one 'init' set up")
+	exportFormat     = flag.String("format", "paper", "How 'export' prints KEYFILE: paper or qr")
+	shareCount       = flag.Int("n", 5, "How many shares 'split' makes")
+	shareThreshold   = flag.Int("k", 3, "How many of the shares from 'split' rebuild the key")
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "%s [OPTIONS] SUBCOMMAND KEYFILE\n", os.Args[0])
 	fmt.Fprintln(os.Stderr, `
+KEYFILE may also be env:NAME, fd:N or cmd:COMMAND to read the key from an
+environment variable, an open file descriptor or the output of a command.
+
 SUBCOMMANDS:
 
 help    this help
 keygen  create a new keyfile to put into KEYFILE
-init    prepare git repo to use KEYFILE
+init    prepare git repo to use KEYFILE, by default $GIT_DIR/grypt/key
+uninit  remove the grypt config from the git repo
 phrase  prompts for a phrase to turn into a key
 check   checks validity of key
+audit   lists files meant for grypt that were committed unencrypted
+status  shows how each file meant for grypt is stored in HEAD and the index
+
+install-hooks  add a pre-commit hook that refuses files staged without encryption
+
+track        track PATTERN: encrypt the files matching PATTERN, via .gitattributes
+untrack      untrack PATTERN: stop encrypting the files matching PATTERN
+
+rotate       rotate KEYFILE NEWKEY: re-encrypt every file from KEYFILE to NEWKEY
+keys         list the keys in KEYFILE; the first one encrypts
+add-key      add-key KEYFILE OLDKEY: also decrypt files encrypted with OLDKEY
+lock         protect KEYFILE with a passphrase, read from the terminal or $GRYPT_PASSPHRASE
+unlock       store KEYFILE without a passphrase again
+agent        hold unlocked keys in memory for -timeout, listening on $GRYPT_AGENT_SOCK
+forget       make the agent drop every key it holds
+export       print KEYFILE as a paper backup, or with -format=qr as QR codes
+import       write KEYFILE from a paper backup typed in on stdin
+split        print -n shares of KEYFILE, any -k of which rebuild it
+combine      combine KEYFILE [SHARE...]: write KEYFILE from shares, by default read from stdin
+
+identity     create an X25519 key in KEYFILE and print its public key
+pubkey       print the public key of the X25519 key in KEYFILE
+add-user     add-user PUBKEY [NAME]: encrypt every file to PUBKEY as well
+remove-user  remove-user PUBKEY|NAME: stop encrypting files to a recipient
+
+encrypt      encrypt IN OUT: encrypt file IN into OUT; - is stdin or stdout
+decrypt      decrypt IN OUT: decrypt file IN into OUT
+cat          cat REV:PATH: print the plaintext of a file in any commit
+             These three use the key 'init' set up, or the one given by -key.
 
 OPTIONS:
 `)
 	flag.PrintDefaults()
-	fmt.Fprintln(os.Stderr, `
-Valid encryption schemes are:
-
- * AES-256/SHA-256          (default, aes256sha256)
- * AES-256/Keccak-256       (keccak, aes256keccak256)
- * AES-256/BLAKE2-256       (blake2, aes256blake2256)
- * Blowfish-448/SHA-256     (blowfish, blowfish448sha256)
- * Blowfish-448/BLAKE2-512  (blakefish, blowfish448blake2512)
-`)
+	fmt.Fprint(os.Stderr, "\nValid encryption schemes are:\n\n")
+	for _, s := range grypt.Schemes() {
+		var names []string
+		if s.ID == grypt.DefaultScheme {
+			names = append(names, "default")
+		}
+		names = append(append(names, s.Aliases...), s.Canonical)
+		note := ""
+		if s.Deprecated {
+			note = ", deprecated"
+		}
+		fmt.Fprintf(os.Stderr, " * %-24s (%s%s)\n", s.Name, strings.Join(names, ", "), note)
+	}
+	fmt.Fprintln(os.Stderr)
 }
 
 func main() {
 	flag.Usage = usage
-	flag.Parse()
+	args = parseArgs()
 	var err error
-	if len(flag.Args()) < 1 {
+	if len(args) < 1 {
 		usage()
 		os.Exit(1)
 	}
-	exe = os.Args[0]
-	keyfile, err = filepath.Abs(flag.Arg(1))
+	keyArg = arg(1)
+	if keyArg == "" && arg(0) == "init" {
+		keyArg = DefaultKeyfile
+	}
+	keyfile, err = resolveKeyfile(keyArg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "unable to make sense of path: %v\n", err)
 		os.Exit(1)
 	}
-	encryptionScheme, err = ParseScheme(*schemeString)
+	encryptionScheme, err = grypt.ParseScheme(*schemeString)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to determine encryption scheme: %v", err)
 		os.Exit(2)
 	}
-	switch flag.Arg(0) {
+	if info, _ := encryptionScheme.Info(); info.Deprecated {
+		fmt.Fprintf(os.Stderr, "warning: %s is deprecated; use it only to match old keys\n", info.Name)
+	}
+	switch arg(0) {
 	case "keygen":
 		err = keygen()
 	case "check":
 		err = checkKey()
 	case "init":
 		err = initRepo()
+	case "uninit":
+		err = uninitRepo()
 	case "phrase":
 		err = keygenFromPhrase()
+	case "rotate":
+		err = rotate(arg(2))
+	case "keys":
+		err = listKeys()
+	case "add-key":
+		err = addKey(arg(2))
+	case "lock":
+		err = lock()
+	case "unlock":
+		err = unlock()
+	case "export":
+		err = export()
+	case "import":
+		err = importKeys()
+	case "split":
+		err = split()
+	case "combine":
+		var shares []string
+		if len(args) > 2 {
+			shares = args[2:]
+		}
+		err = combine(shares)
+	case "agent":
+		err = agent()
+	case "forget":
+		err = forget()
+	case "identity":
+		err = identity()
+	case "pubkey":
+		err = pubkey()
+	case "add-user":
+		err = addUser(arg(1), arg(2))
+	case "remove-user":
+		err = removeUser(arg(1))
 	case "clean":
-		err = clean()
+		err = clean(arg(2))
 	case "smudge":
-		err = smudge()
-	case "diff":
-		err = diff(flag.Arg(2))
+		err = smudge(arg(2))
+	case "filter-process":
+		err = filterProcess(os.Stdin, os.Stdout)
+	case "track":
+		err = track(arg(1))
+	case "untrack":
+		err = untrack(arg(1))
+	case "install-hooks":
+		err = installHooks()
+	case "pre-commit":
+		err = preCommit()
+	case "status":
+		err = status()
+	case "audit":
+		err = audit()
+	case "merge":
+		err = merge(arg(2), arg(3), arg(4), arg(5), arg(6))
+	case "textconv":
+		err = textconv(arg(2))
+	case "encrypt":
+		err = encryptPath(arg(1), arg(2))
+	case "decrypt":
+		err = decryptPath(arg(1), arg(2))
+	case "cat":
+		err = catBlob(arg(1))
 	default:
 		usage()
 		os.Exit(1)
@@ -107,30 +218,173 @@ func main() {
 	os.Exit(0)
 }
 
+// Subcommands run by git, whose arguments are paths that may well start
+// with a dash
+var gitSubcommands = map[string]bool{
+	"clean": true, "smudge": true, "textconv": true, "merge": true,
+	"filter-process": true, "pre-commit": true,
+}
+
+// parse the command line: options, the subcommand, then its arguments,
+// among which more options may come, as in `grypt split -n 5 -k 3 KEYFILE'.
+// Arguments of the subcommands git runs are left alone, and so is
+// everything after "--".
+func parseArgs() []string {
+	flag.Parse()
+	all := flag.Args()
+	if len(all) == 0 || gitSubcommands[all[0]] {
+		return all
+	}
+	rest, positional := all[1:], []string{all[0]}
+	for len(rest) > 0 {
+		flag.CommandLine.Parse(rest)
+		if n := len(rest) - flag.NArg(); n > 0 && rest[n-1] == "--" {
+			return append(positional, flag.Args()...)
+		}
+		if rest = flag.Args(); len(rest) > 0 {
+			positional, rest = append(positional, rest[0]), rest[1:]
+		}
+	}
+	return positional
+}
+
+// the nth argument from parseArgs, or ""
+func arg(n int) string {
+	if n < len(args) {
+		return args[n]
+	}
+	return ""
+}
+
 func keygen() error {
-	k, err := NewKey(rand.Reader, encryptionScheme)
+	k, err := grypt.NewKey(rand.Reader, encryptionScheme)
 	if err != nil {
 		return fmt.Errorf("failure generating key: %v", err)
 	}
 	return WriteKey(keyfile, k)
 }
 
+// The salt and scrypt parameters live in PhraseFile at the top of the work
+// tree (or the current directory outside of one), so that the passphrase
+// gives the same key in every clone. Keys from the unsalted derivation of
+// earlier versions are not derived again, so those have to be kept as files.
 func keygenFromPhrase() error {
-	hkdf := hkdf.New(sha256.New, readPhrase(), nil, nil)
-	k, err := NewKey(hkdf, encryptionScheme)
+	f := PhraseFile
+	if top, err := git("rev-parse", "--show-toplevel"); err == nil {
+		f = filepath.Join(strings.TrimSpace(string(top)), PhraseFile)
+	}
+	kp, created, err := loadKDFParams(f, *scryptN, *scryptR, *scryptP)
+	if err != nil {
+		return err
+	}
+	phrase, err := readPhrase("passphrase: ")
+	if err != nil {
+		return err
+	}
+	k, err := kp.Key(phrase, encryptionScheme)
 	if err != nil {
 		return fmt.Errorf("failure generating key: %v", err)
 	}
+	if created {
+		fmt.Fprintf(os.Stderr, "wrote %s; commit it so the passphrase gives the same key elsewhere\n", f)
+	}
 	return WriteKey(keyfile, k)
 }
 
-func initRepo() error {
+func identity() error {
+	k, err := grypt.NewIdentity(rand.Reader, encryptionScheme)
+	if err != nil {
+		return fmt.Errorf("failure generating key: %v", err)
+	}
+	if err = WriteKey(keyfile, k); err != nil {
+		return err
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(pub)
+	return nil
+}
+
+func pubkey() error {
+	k, err := ReadKey(keyfile)
+	if err != nil {
+		return err
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(pub)
+	return nil
+}
+
+// the git config commands that point the grypt filter, diff and merge
+// drivers at key location 'f', which is resolved when they run. grypt.key
+// keeps it for the commands git does not run.
+func filterConfig(f string) [][]string {
+	exe, q := shellQuote(portableExe()), shellQuote(f)
 	cfgs := [][]string{
-		[]string{"git", "config", "filter.grypt.smudge", fmt.Sprintf("%s smudge %s", exe, keyfile)},
-		[]string{"git", "config", "filter.grypt.clean", fmt.Sprintf("%s clean %s", exe, keyfile)},
-		[]string{"git", "config", "filter.grypt.textconv", fmt.Sprintf("%s textconv %s", exe, keyfile)},
+		[]string{"git", "config", "grypt.key", f},
+		[]string{"git", "config", "filter.grypt.smudge", fmt.Sprintf("%s smudge %s %%f", exe, q)},
+		[]string{"git", "config", "filter.grypt.clean", fmt.Sprintf("%s clean %s %%f", exe, q)},
+		[]string{"git", "config", "filter.grypt.process", fmt.Sprintf("%s filter-process %s", exe, q)},
+		[]string{"git", "config", "diff.grypt.textconv", fmt.Sprintf("%s textconv %s", exe, q)},
+		[]string{"git", "config", "merge.grypt.name", "grypt decrypting merge"},
+		[]string{"git", "config", "merge.grypt.driver", fmt.Sprintf("%s merge %s %%O %%A %%B %%L %%P", exe, q)},
+	}
+	if *cacheTextconv {
+		cfgs = append(cfgs, []string{"git", "config", "diff.grypt.cachetextconv", "true"})
+	}
+	return cfgs
+}
+
+// run config commands as returned by filterConfig
+func setConfig(cfgs [][]string) error {
+	for _, cmd := range cfgs {
+		c := exec.Command(cmd[0], cmd[1:]...)
+		if c.Run() != nil {
+			return fmt.Errorf("unable to set config option: %s", cmd)
+		}
+	}
+	return nil
+}
+
+func listKeys() error {
+	ring, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return err
+	}
+	for n, k := range ring {
+		use := "decrypt"
+		if n == 0 {
+			use = "encrypt"
+		}
+		fmt.Printf("%x  %-24s %s\n", k.ID(), k.Scheme, use)
 	}
+	return nil
+}
 
+// add the keys in file 'f' to the end of the ring in keyfile
+func addKey(f string) error {
+	ring, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return err
+	}
+	more, err := ReadKeyRing(f)
+	if err != nil {
+		return err
+	}
+	for _, k := range more {
+		if !ring.Has(k) {
+			ring = append(ring, k)
+		}
+	}
+	return WriteKeyRing(keyfile, ring)
+}
+
+func initRepo() error {
 	// check if we have a HEAD
 	r := exec.Command("git", "rev-parse", "HEAD")
 	hasHEAD := true
@@ -147,11 +401,15 @@ func initRepo() error {
 	}
 
 	// set config options
-	for _, cmd := range cfgs {
-		c := exec.Command(cmd[0], cmd[1:]...)
-		if c.Run() != nil {
-			return fmt.Errorf("unable to set config option: %s", cmd)
-		}
+	if _, err := os.Stat(keyfile); err != nil && !isKeySource(keyfile) {
+		return fmt.Errorf("no key at %s, create one with `grypt keygen %s'", keyfile, shellQuote(keyArg))
+	}
+	loc, err := portableKeyfile(keyArg)
+	if err != nil {
+		return err
+	}
+	if err := setConfig(filterConfig(loc)); err != nil {
+		return err
 	}
 
 	// run a forced checkout to decrypt any encrypted files
@@ -166,6 +424,73 @@ func initRepo() error {
 	return nil
 }
 
+// The inverse of initRepo: drop the grypt config, and with -checkout write
+// the files back out as ciphertext. Files with uncommitted changes are left
+// alone, since their plaintext is all there is.
+func uninitRepo() error {
+	if err := chdirTop(); err != nil {
+		return err
+	}
+	out, err := git("status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return err
+	}
+	var changed []string
+	entries := splitZ(out)
+	for i := 0; i < len(entries); i++ {
+		// XY SP <path>, followed by the original path of a rename or copy
+		e := entries[i]
+		if len(e) < 4 {
+			continue
+		}
+		changed = append(changed, e[3:])
+		if e[0] == 'R' || e[0] == 'C' {
+			i++
+		}
+	}
+	if changed, err = grypted(changed); err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		fmt.Fprintln(os.Stderr, "warning: these files have uncommitted changes, which stay in the clear:")
+		for _, p := range changed {
+			fmt.Fprintf(os.Stderr, "\t%s\n", p)
+		}
+	}
+
+	removed := false
+	for _, section := range []string{"grypt", "filter.grypt", "diff.grypt", "merge.grypt"} {
+		if _, err := git("config", "--remove-section", section); err == nil {
+			removed = true
+		}
+	}
+	if !removed {
+		return fmt.Errorf("grypt is not set up in this repository")
+	}
+	// textconv output cached for diffs is plaintext
+	git("update-ref", "-d", "refs/notes/textconv/grypt")
+
+	if !*uninitCheckout {
+		fmt.Fprintln(os.Stderr, "the work tree still holds plaintext; run `grypt -checkout uninit' to replace it with ciphertext")
+		return nil
+	}
+	staged, err := lsFiles()
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, e := range staged {
+		if !contains(changed, e.Path) {
+			paths = append(paths, e.Path)
+		}
+	}
+	if paths, err = grypted(paths); err != nil || len(paths) == 0 {
+		return err
+	}
+	_, err = git(append([]string{"--literal-pathspecs", "checkout", "--"}, paths...)...)
+	return err
+}
+
 func checkKey() error {
 	_, err := ReadKey(keyfile)
 	if err != nil {
@@ -174,24 +499,178 @@ func checkKey() error {
 	return nil
 }
 
-func clean() error {
+func clean(path string) error {
 	k, err := ReadKey(keyfile)
 	if err != nil {
 		return fmt.Errorf("error reading key: %v", err)
 	}
-	return Encrypt(os.Stdin, os.Stdout, k)
+	return cleanFile(path, k, os.Stdin, os.Stdout)
 }
 
-func smudge() error {
-	k, err := ReadKey(keyfile)
+// encrypt the contents of 'path' from i to o under k
+func cleanFile(path string, k grypt.Key, i io.Reader, o io.Writer) error {
+	if k.Version != grypt.KeyX25519 {
+		if path != "" {
+			if blob, err := gitPipe("cat-file", "blob", ":"+path); err == nil {
+				defer blob.Close()
+				if b := bufio.NewReader(blob); grypt.IsLegacy(b) {
+					return keepLegacy(b, k, i, o)
+				}
+			}
+		}
+		return grypt.Encrypt(i, o, k)
+	}
+
+	// git runs filters from the top of the work tree
+	recipients, err := ReadRecipients(RecipientsFile)
+	if err != nil {
+		return fmt.Errorf("error reading recipients: %v", err)
+	}
+	dataKey, err := fileDataKey(path, k, recipients)
+	if err != nil {
+		return err
+	}
+	return grypt.EncryptTo(i, o, dataKey, recipients)
+}
+
+// A file committed by the first grypt is staged as it is for as long as its
+// plaintext does not change, or it would look modified as soon as it is
+// checked out. That format was never streamed, so neither is this.
+func keepLegacy(blob io.Reader, k grypt.Key, i io.Reader, o io.Writer) error {
+	legacy, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+	plain, err := ioutil.ReadAll(i)
+	if err != nil {
+		return err
+	}
+	old := new(bytes.Buffer)
+	if grypt.DecryptRing(bytes.NewReader(legacy), old, grypt.KeyRing{k}) == nil && bytes.Equal(old.Bytes(), plain) {
+		_, err = o.Write(legacy)
+		return err
+	}
+	return grypt.Encrypt(bytes.NewReader(plain), o, k)
+}
+
+// The data key to encrypt 'path' with: the one its staged blob already
+// uses, unless someone has been removed from the recipients since, or
+// else a fresh one.
+func fileDataKey(path string, identity grypt.Key, recipients []grypt.PublicKey) (grypt.Key, error) {
+	if path != "" {
+		if blob, err := gitPipe("cat-file", "blob", ":"+path); err == nil {
+			k, old, err := grypt.DataKey(blob, identity)
+			blob.Close()
+			if err == nil && k.Scheme == identity.Scheme && subset(old, recipients) {
+				return k, nil
+			}
+		}
+	}
+	return grypt.NewKey(rand.Reader, identity.Scheme)
+}
+
+func subset(a, b []grypt.PublicKey) bool {
+outer:
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Blobs that were committed before grypt was set up are checked out as they
+// are, with a warning, rather than failing the whole checkout.
+func smudge(path string) error {
+	ring, err := ReadKeyRing(keyfile)
 	if err != nil {
 		return fmt.Errorf("error reading key: %v", err)
 	}
-	return Decrypt(os.Stdin, os.Stdout, k)
+	return smudgeFile(path, ring, os.Stdin, os.Stdout)
 }
 
-func diff(f string) error {
-	k, err := ReadKey(keyfile)
+// decrypt the contents of 'path' from i to o with the keys in ring
+func smudgeFile(path string, ring grypt.KeyRing, i io.Reader, o io.Writer) error {
+	encrypted, err := grypt.DecryptOrCopy(i, o, ring)
+	if path == "" {
+		path = "a file"
+	}
+	if err == grypt.ErrLegacy {
+		return fmt.Errorf("%s can not be decrypted: it is in the original grypt format, which only the version 1 key that wrote it decrypts", path)
+	}
+	if err == nil && !encrypted {
+		fmt.Fprintf(os.Stderr, "warning: %s is not encrypted in the repository, run `grypt audit'\n", path)
+	}
+	return err
+}
+
+// list the files in HEAD that should be encrypted but aren't. With a key
+// at hand, also those that it does not decrypt. Files in the original
+// grypt format are encrypted, but without a key they are listed apart, as
+// only a version 1 key decrypts them.
+func audit() error {
+	var ring grypt.KeyRing
+	if f, err := commandKeyfile(); err == nil {
+		if ring, err = ReadKeyRing(f); err != nil {
+			return fmt.Errorf("error reading key: %v", err)
+		}
+	}
+	if err := chdirTop(); err != nil {
+		return err
+	}
+	entries, err := lsTree("HEAD")
+	if err != nil {
+		return err
+	}
+	blobs, err := classifyBlobs(entries, ring)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(blobs))
+	for p := range blobs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	plain := 0
+	var legacy, bad []string
+	for _, p := range paths {
+		switch b := blobs[p]; b.Kind {
+		case blobPlaintext:
+			fmt.Println(p)
+			plain++
+		case blobLegacy:
+			legacy = append(legacy, p)
+		case blobInvalid, blobUnknownKey:
+			bad = append(bad, fmt.Sprintf("%s: %s", p, b.Error))
+		}
+	}
+	if len(legacy) != 0 && ring == nil {
+		fmt.Fprintln(os.Stderr, "these files are in the original grypt format, and decrypt only with the version 1\nkey that wrote them. Do not renormalize them: without that key the work tree\nholds their ciphertext, which would be encrypted again.")
+		for _, p := range legacy {
+			fmt.Fprintf(os.Stderr, "\t%s\n", p)
+		}
+	}
+	if len(bad) != 0 {
+		fmt.Fprintln(os.Stderr, "these files are encrypted, but can not be decrypted. Do not renormalize them:\nthe work tree holds their ciphertext, which would be encrypted again.")
+		for _, p := range bad {
+			fmt.Fprintf(os.Stderr, "\t%s\n", p)
+		}
+	}
+	if plain != 0 {
+		return fmt.Errorf("%d of %d files are committed unencrypted; re-add them with `git add --renormalize'", plain, len(paths))
+	}
+	if len(bad) != 0 {
+		return fmt.Errorf("%d of %d files can not be decrypted", len(bad), len(paths))
+	}
+	return nil
+}
+
+// show the plaintext of file 'f' for git diff, log -p and friends
+func textconv(f string) error {
+	ring, err := ReadKeyRing(keyfile)
 	if err != nil {
 		return err
 	}
@@ -200,5 +679,6 @@ func diff(f string) error {
 		return err
 	}
 	defer file.Close()
-	return Decrypt(file, os.Stdout, k)
+	_, err = grypt.DecryptOrCopy(file, os.Stdout, ring)
+	return err
 }
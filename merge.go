@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// Run by git as merge.grypt.driver with the base, ours and theirs blobs in
+// files 'o', 'a' and 'b'. They are decrypted into a scratch directory in
+// $GIT_DIR, merged with `git merge-file', and the result is encrypted back
+// into 'a'. A conflicted result keeps its markers, so they are readable
+// once git checks it out.
+func merge(o, a, b, markerSize, path string) error {
+	if path == "" {
+		path = a
+	}
+	ring, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return fmt.Errorf("error reading key: %v", err)
+	}
+	gitDir, err := git("rev-parse", "--git-dir")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(strings.TrimSpace(string(gitDir)), "grypt-merge")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	args := []string{"merge-file", "-p", "-L", "ours", "-L", "base", "-L", "theirs"}
+	if markerSize != "" {
+		args = append(args, "--marker-size="+markerSize)
+	}
+	for n, f := range []string{a, o, b} {
+		plain := filepath.Join(tmp, []string{"ours", "base", "theirs"}[n])
+		if err = decryptFile(f, plain, ring); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		args = append(args, plain)
+	}
+
+	c := exec.Command("git", args...)
+	out := new(bytes.Buffer)
+	c.Stdout, c.Stderr = out, os.Stderr
+	conflicts := 0
+	if err = c.Run(); err != nil {
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			return err
+		}
+		// merge-file exits with the number of conflicts, or negative on error
+		conflicts = exit.Sys().(syscall.WaitStatus).ExitStatus()
+		if conflicts < 0 || conflicts > 127 {
+			return fmt.Errorf("`git merge-file' failed on %s: %v", path, err)
+		}
+	}
+
+	result, err := os.Create(a)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	if err = cleanFile(path, ring[0], out, result); err != nil {
+		return err
+	}
+	if conflicts != 0 {
+		return fmt.Errorf("%d conflicts in %s", conflicts, path)
+	}
+	return nil
+}
+
+// decrypt file 'in' into a new file 'out' that only we can read
+func decryptFile(in, out string, ring grypt.KeyRing) error {
+	i, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer i.Close()
+	o, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+	_, err = grypt.DecryptOrCopy(i, o, ring)
+	return err
+}
@@ -3,23 +3,22 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"fmt"
-	"io"
 	"os"
 
 	"code.google.com/p/go.crypto/ssh/terminal"
 )
 
-func readPhrase() []byte {
-	h := sha256.New()
-	fmt.Fprintf(os.Stderr, "passphrase: ")
-	p, err := terminal.ReadPassword(0)
+// read a passphrase from the controlling terminal, which still works when
+// stdin is a pipe, as it is when git runs the filters
+func readPhrase(prompt string) ([]byte, error) {
+	tty, err := os.Open("/dev/tty")
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("unable to read passphrase: %v", err)
 	}
-	io.Copy(h, bytes.NewBuffer(p))
-	fmt.Println()
-	return h.Sum(nil)
+	defer tty.Close()
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return p, err
 }
@@ -4,11 +4,8 @@ package main
 
 import (
 	"fmt"
-	"os"
 )
 
-func readPhrase() []byte {
-	fmt.Fprintln(os.Stderr, "Passphrase is not supported on this platorm")
-	os.Exit(1)
-	return []byte{}
+func readPhrase(prompt string) ([]byte, error) {
+	return nil, fmt.Errorf("Passphrase is not supported on this platorm")
 }
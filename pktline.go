@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Largest payload of a pkt-line, per gitprotocol-common(5)
+const maxPktPayload = 65516
+
+// read one pkt-line. A flush packet reports flush and no data.
+func readPkt(r io.Reader) (data []byte, flush bool, err error) {
+	var head [4]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return nil, false, err
+	}
+	n, err := strconv.ParseUint(string(head[:]), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("bad pkt-line length %q", head)
+	}
+	if n == 0 {
+		return nil, true, nil
+	}
+	if n < 4 || n > maxPktPayload+4 {
+		return nil, false, fmt.Errorf("bad pkt-line length %q", head)
+	}
+	data = make([]byte, n-4)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, false, err
+	}
+	return data, false, nil
+}
+
+func writePkt(w io.Writer, data []byte) error {
+	if _, err := fmt.Fprintf(w, "%04x", len(data)+4); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func writeFlush(w io.Writer) error {
+	_, err := io.WriteString(w, "0000")
+	return err
+}
+
+// read text pkt-lines up to a flush, without their newlines
+func readPktList(r io.Reader) ([]string, error) {
+	var lines []string
+	for {
+		data, flush, err := readPkt(r)
+		if err != nil {
+			return lines, err
+		}
+		if flush {
+			return lines, nil
+		}
+		lines = append(lines, strings.TrimSuffix(string(data), "\n"))
+	}
+}
+
+// write text pkt-lines and a flush
+func writePktList(w io.Writer, lines ...string) error {
+	for _, l := range lines {
+		if err := writePkt(w, []byte(l+"\n")); err != nil {
+			return err
+		}
+	}
+	return writeFlush(w)
+}
+
+// pktReader reads the payloads of pkt-lines as one stream, up to a flush
+type pktReader struct {
+	r    io.Reader
+	buf  []byte
+	done bool
+}
+
+func (p *pktReader) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 {
+		if p.done {
+			return 0, io.EOF
+		}
+		data, flush, err := readPkt(p.r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		p.buf, p.done = data, flush
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// pktWriter splits a stream into pkt-lines. It does not write the flush.
+type pktWriter struct {
+	w io.Writer
+}
+
+func (p pktWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		c := len(b)
+		if c > maxPktPayload {
+			c = maxPktPayload
+		}
+		if err := writePkt(p.w, b[:c]); err != nil {
+			return n, err
+		}
+		n += c
+		b = b[c:]
+	}
+	return n, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of a key location inside the repository's git directory. It is
+// kept as is in the config and resolved each time grypt runs, so the
+// repository can move.
+const gitDirPrefix = "$GIT_DIR/"
+
+// Where `init' expects the key when not given one
+const DefaultKeyfile = gitDirPrefix + "grypt/key"
+
+// the git directory shared by all work trees of the repository
+func gitCommonDir() (string, error) {
+	out, err := git("rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(strings.TrimSpace(string(out)))
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	return dir, nil
+}
+
+// turn a key location from the command line or the config into a path
+func resolveKeyfile(loc string) (string, error) {
+	if isKeySource(loc) {
+		return loc, nil
+	}
+	if !strings.HasPrefix(loc, gitDirPrefix) {
+		return filepath.Abs(loc)
+	}
+	dir, err := gitCommonDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(loc[len(gitDirPrefix):])), nil
+}
+
+// the key location to write into the config: relative to the git
+// directory when the key is inside it, otherwise absolute
+func portableKeyfile(loc string) (string, error) {
+	if isKeySource(loc) || strings.HasPrefix(loc, gitDirPrefix) {
+		return loc, nil
+	}
+	f, err := filepath.Abs(loc)
+	if err != nil {
+		return "", err
+	}
+	dir, err := gitCommonDir()
+	if err != nil {
+		return f, nil
+	}
+	if real, err := filepath.EvalSymlinks(filepath.Dir(f)); err == nil {
+		f = filepath.Join(real, filepath.Base(f))
+	}
+	rel, err := filepath.Rel(dir, f)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return f, nil
+	}
+	return gitDirPrefix + filepath.ToSlash(rel), nil
+}
+
+// How the config should run grypt: by name when that finds this binary on
+// PATH, so that reinstalling it keeps working, and by absolute path
+// otherwise.
+func portableExe() string {
+	self, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		self = os.Args[0]
+	}
+	if abs, err := filepath.Abs(self); err == nil {
+		self = abs
+	}
+	if found, err := exec.LookPath("grypt"); err == nil {
+		a, aerr := os.Stat(self)
+		b, berr := os.Stat(found)
+		if aerr == nil && berr == nil && os.SameFile(a, b) {
+			return "grypt"
+		}
+	}
+	return self
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// A QR code, as printed by `export -format=qr'. Only what a key needs is
+// here: byte mode, error correction level M, versions 1 to 10.
+type qrCode struct {
+	version int
+	size    int
+	// dark modules, by row then column
+	modules [][]bool
+	// modules of the finder, timing, alignment, format and version
+	// patterns, which the data and the mask skip
+	function [][]bool
+}
+
+// per version, at error correction level M: codewords in all, error
+// correction codewords per block, and the number of blocks
+var qrVersionsM = [...]struct{ total, ecc, blocks int }{
+	1: {26, 10, 1}, 2: {44, 16, 1}, 3: {70, 26, 1}, 4: {100, 18, 2}, 5: {134, 24, 2},
+	6: {172, 16, 4}, 7: {196, 18, 4}, 8: {242, 22, 4}, 9: {292, 22, 5}, 10: {346, 26, 5},
+}
+
+// centres of the alignment patterns, in both directions
+var qrAlignment = [...][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30},
+	6: {6, 34}, 7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+// encode 'data' in the smallest QR code that holds it
+func newQRCode(data []byte) (*qrCode, error) {
+	for v := 1; v < len(qrVersionsM); v++ {
+		if codewords, ok := qrData(v, data); ok {
+			q := &qrCode{version: v, size: 17 + 4*v}
+			q.modules = make([][]bool, q.size)
+			q.function = make([][]bool, q.size)
+			for y := range q.modules {
+				q.modules[y] = make([]bool, q.size)
+				q.function[y] = make([]bool, q.size)
+			}
+			q.drawFunctionPatterns()
+			q.drawCodewords(qrInterleave(v, codewords))
+			q.applyBestMask()
+			return q, nil
+		}
+	}
+	return nil, fmt.Errorf("%d bytes do not fit in a QR code", len(data))
+}
+
+// the data codewords of 'data' in byte mode, padded out to the capacity
+// of version 'v'
+func qrData(v int, data []byte) ([]byte, bool) {
+	info := qrVersionsM[v]
+	capacity := info.total - info.ecc*info.blocks
+	countBits := 8
+	if v >= 10 {
+		countBits = 16
+	}
+	if 4+countBits+8*len(data) > 8*capacity {
+		return nil, false
+	}
+	var bits []bool
+	put := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, val>>uint(i)&1 == 1)
+		}
+	}
+	put(0x4, 4)
+	put(len(data), countBits)
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	// terminator, then up to a whole byte
+	for i := 0; i < 4 && len(bits) < 8*capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	out := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		out = append(out, b)
+	}
+	for pad := byte(0xEC); len(out) < capacity; pad ^= 0xEC ^ 0x11 {
+		out = append(out, pad)
+	}
+	return out, true
+}
+
+// split the data codewords into blocks, add error correction to each and
+// interleave them. The short blocks come first; the long ones have one
+// more data codeword.
+func qrInterleave(v int, data []byte) []byte {
+	info := qrVersionsM[v]
+	short := info.total / info.blocks
+	numShort := info.blocks - info.total%info.blocks
+	divisor := rsDivisor(info.ecc)
+	blocks := make([][]byte, info.blocks)
+	for i, k := 0, 0; i < info.blocks; i++ {
+		n := short - info.ecc
+		if i >= numShort {
+			n++
+		}
+		dat := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(dat, divisor)
+		if i < numShort {
+			// placeholder, skipped below
+			dat = append(dat, 0)
+		}
+		blocks[i] = append(dat, ecc...)
+	}
+	var out []byte
+	for i := range blocks[0] {
+		for j, b := range blocks {
+			if i != short-info.ecc || j >= numShort {
+				out = append(out, b[i])
+			}
+		}
+	}
+	return out
+}
+
+// generator polynomial of Reed-Solomon codes with 'degree' error
+// correction codewords, leading coefficient left out
+func rsDivisor(degree int) []byte {
+	out := make([]byte, degree)
+	out[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range out {
+			out[j] = gfMul(out[j], root)
+			if j+1 < len(out) {
+				out[j] ^= out[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return out
+}
+
+// the error correction codewords of 'data'
+func rsRemainder(data, divisor []byte) []byte {
+	out := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ out[0]
+		copy(out, out[1:])
+		out[len(out)-1] = 0
+		for i := range out {
+			out[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return out
+}
+
+// multiplication in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) drawFunctionPatterns() {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		// the finder and its light separator
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < q.size && y >= 0 && y < q.size {
+					d := chebyshev(dx, dy)
+					q.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	pos := qrAlignment[q.version]
+	for i, x := range pos {
+		for j, y := range pos {
+			// those are where the finders are
+			if i == 0 && j == 0 || i == 0 && j == len(pos)-1 || i == len(pos)-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(x+dx, y+dy, chebyshev(dx, dy) != 1)
+				}
+			}
+		}
+	}
+	// reserve the format bits until the mask is known
+	q.drawFormatBits(0)
+	if q.version >= 7 {
+		rem := q.version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := q.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>uint(i)&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+func chebyshev(dx, dy int) int {
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+// the format information: level M, which is 00, and 'mask', BCH coded
+func qrFormatBits(mask int) int {
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (q *qrCode) drawFormatBits(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	// around the top left finder
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	// and split between the other two
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// place the codewords in two-module columns zigzagging up and down from
+// the right, stepping around the vertical timing pattern
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = data[i>>3]>>uint(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// XOR 'mask' onto the data modules; doing it twice undoes it
+func (q *qrCode) applyMask(mask int) {
+	for y := range q.modules {
+		for x := range q.modules[y] {
+			if !q.function[y][x] && qrMask(mask, x, y) {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyBestMask() {
+	best, lowest := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); lowest < 0 || p < lowest {
+			best, lowest = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+}
+
+// how badly the symbol scores on the four rules of the specification:
+// long runs, 2x2 blocks, patterns that look like finders, and an uneven
+// share of dark modules
+func (q *qrCode) penalty() int {
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			x, y = y, x
+		}
+		if x < 0 || x >= q.size || y < 0 || y >= q.size {
+			return false
+		}
+		return q.modules[y][x]
+	}
+	finder := []bool{true, false, true, true, true, false, true}
+	p := 0
+	for _, t := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			run := 1
+			for x := 1; x <= q.size; x++ {
+				if x < q.size && at(x, y, t) == at(x-1, y, t) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += run - 2
+				}
+				run = 1
+			}
+			for x := -4; x < q.size; x++ {
+				match := true
+				for i, dark := range finder {
+					if at(x+i, y, t) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				before, after := true, true
+				for i := 1; i <= 4; i++ {
+					before = before && !at(x-i, y, t)
+					after = after && !at(x+6+i, y, t)
+				}
+				if before || after {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.modules[y][x]
+				if q.modules[y-1][x] == c && q.modules[y][x-1] == c && q.modules[y-1][x-1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	// 10 for every 5% the dark share is off 50%, beyond the first 5%
+	total := q.size * q.size
+	if k := (abs(dark*20-total*10)+total-1)/total - 1; k > 0 {
+		p += 10 * k
+	}
+	return p
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Print the code to a terminal, two rows of modules to a line of half
+// blocks. The colours are set explicitly, so that it scans on light and
+// dark terminals alike, and it keeps the four module quiet zone.
+func (q *qrCode) WriteTo(w io.Writer) (int64, error) {
+	const quiet = 4
+	light := func(x, y int) bool {
+		if x < 0 || x >= q.size || y < 0 || y >= q.size {
+			return true
+		}
+		return !q.modules[y][x]
+	}
+	var n int64
+	for y := -quiet; y < q.size+quiet; y += 2 {
+		line := "\x1b[40;97m"
+		for x := -quiet; x < q.size+quiet; x++ {
+			switch top, bottom := light(x, y), light(x, y+1) && y+1 < q.size+quiet; {
+			case top && bottom:
+				line += "█"
+			case top:
+				line += "▀"
+			case bottom:
+				line += "▄"
+			default:
+				line += " "
+			}
+		}
+		m, err := io.WriteString(w, line+"\x1b[0m\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// "HELLO WORLD" at version 1-M, from the QR code specification's example
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if ecc := rsRemainder(data, rsDivisor(10)); !bytes.Equal(ecc, expected) {
+		t.Errorf("expected error correction %v, got %v", expected, ecc)
+	}
+}
+
+// Read the codewords back out of codes of each version, the way a scanner
+// would once it has found the symbol.
+func TestQRCode(t *testing.T) {
+	for _, size := range []int{10, 50, 100, 150, 200} {
+		data := bytes.Repeat([]byte("grypt"), size/5)
+		q, err := newQRCode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codewords, _ := qrData(q.version, data)
+		expected := qrInterleave(q.version, codewords)
+
+		// both copies of the format information agree, and give the mask
+		var first, second int
+		for i := 14; i >= 0; i-- {
+			var a, b bool
+			switch {
+			case i <= 5:
+				a = q.modules[i][8]
+			case i <= 7:
+				a = q.modules[i+1][8]
+			case i == 8:
+				a = q.modules[8][7]
+			default:
+				a = q.modules[8][14-i]
+			}
+			if i < 8 {
+				b = q.modules[8][q.size-1-i]
+			} else {
+				b = q.modules[q.size-15+i][8]
+			}
+			first, second = first<<1|bit(a), second<<1|bit(b)
+		}
+		if first != second {
+			t.Fatalf("version %d: format information %015b and %015b differ", q.version, first, second)
+		}
+		mask := -1
+		for m := 0; m < 8; m++ {
+			if qrFormatBits(m) == first {
+				mask = m
+			}
+		}
+		if mask < 0 {
+			t.Fatalf("version %d: format information %015b is not level M", q.version, first)
+		}
+
+		q.applyMask(mask)
+		var got []byte
+		var b byte
+		var n uint
+		for right := q.size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := 0; vert < q.size; vert++ {
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				for x := right; x >= right-1; x-- {
+					if q.function[y][x] {
+						continue
+					}
+					b = b<<1 | byte(bit(q.modules[y][x]))
+					if n++; n%8 == 0 {
+						got = append(got, b)
+					}
+				}
+			}
+		}
+		if len(got) < len(expected) || !bytes.Equal(got[:len(expected)], expected) {
+			t.Errorf("version %d: codewords were not read back as written", q.version)
+		}
+	}
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// File at the top of the work tree listing the public keys files are
+// encrypted to, one per line, each optionally followed by a name.
+const RecipientsFile = ".grypt-recipients"
+
+// read the public keys listed in file 'f'
+func ReadRecipients(f string) ([]grypt.PublicKey, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	keys, err := grypt.ParseRecipients(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f, err)
+	}
+	return keys, nil
+}
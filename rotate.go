@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// re-encrypt every grypt file in the index from keyfile to 'newKeyfile',
+// stage the results and point the filter config at 'newKeyfile'. The old
+// keys are added to 'newKeyfile' so that earlier commits stay readable.
+func rotate(newKeyfile string) error {
+	if newKeyfile == "" {
+		return fmt.Errorf("no new key given")
+	}
+	newLoc, err := portableKeyfile(newKeyfile)
+	if err != nil {
+		return err
+	}
+	if newKeyfile, err = resolveKeyfile(newKeyfile); err != nil {
+		return err
+	}
+	oldRing, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return fmt.Errorf("error reading key: %v", err)
+	}
+	newKey, err := ReadKey(newKeyfile)
+	if err != nil {
+		return fmt.Errorf("error reading new key: %v", err)
+	}
+	if oldRing[0].Version == grypt.KeyX25519 || newKey.Version == grypt.KeyX25519 {
+		return fmt.Errorf("X25519 keys are not rotated; use add-user and remove-user")
+	}
+	if err = chdirTop(); err != nil {
+		return err
+	}
+	if err = requireClean("rotate"); err != nil {
+		return err
+	}
+
+	entries, err := lsFiles()
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]treeEntry, len(entries))
+	paths := make([]string, 0, len(entries))
+	for _, e := range entries {
+		byPath[e.Path] = e
+		paths = append(paths, e.Path)
+	}
+	if paths, err = grypted(paths); err != nil {
+		return err
+	}
+
+	cat, err := newCatFile()
+	if err != nil {
+		return err
+	}
+	defer cat.Close()
+	index := new(bytes.Buffer)
+	for _, p := range paths {
+		var blob string
+		err := cat.Read(byPath[p].Blob, func(r io.Reader) (err error) {
+			blob, err = hashObject(func(w io.Writer) error {
+				return reencrypt(r, w, oldRing, newKey)
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		fmt.Fprintf(index, "%s %s\t%s\x00", byPath[p].Mode, blob, p)
+	}
+	c := exec.Command("git", "update-index", "-z", "--index-info")
+	c.Stdin = index
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("`git update-index' failed: %v", err)
+	}
+
+	ring := grypt.KeyRing{newKey}
+	for _, k := range oldRing {
+		if !ring.Has(k) {
+			ring = append(ring, k)
+		}
+	}
+	if err = WriteKeyRing(newKeyfile, ring); err != nil {
+		return err
+	}
+	if err = setConfig(filterConfig(newLoc)); err != nil {
+		return err
+	}
+	fmt.Printf("re-encrypted %d files; commit them to finish the rotation\n", len(paths))
+	return nil
+}
+
+// reencrypt decrypts r with a key from old and encrypts it to w with newKey. Data
+// that was not encrypted to begin with is encrypted too.
+func reencrypt(r io.Reader, w io.Writer, old grypt.KeyRing, newKey grypt.Key) error {
+	pr, pw := io.Pipe()
+	done := make(chan bool)
+	go func() {
+		_, err := grypt.DecryptOrCopy(r, pw, old)
+		pw.CloseWithError(err)
+		close(done)
+	}()
+	err := grypt.Encrypt(pr, w, newKey)
+	// r is the caller's once this returns, so wait for the decryption to
+	// give it up even when Encrypt stopped early
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	return err
+}
+
+// hashObject writes what fn produces to the object database, bypassing the
+// filters, and returns the object id.
+func hashObject(fn func(io.Writer) error) (string, error) {
+	c := exec.Command("git", "hash-object", "-w", "--no-filters", "--stdin")
+	in, err := c.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	out := new(bytes.Buffer)
+	c.Stdout = out
+	if err = c.Start(); err != nil {
+		return "", err
+	}
+	err = fn(in)
+	in.Close()
+	if werr := c.Wait(); err == nil && werr != nil {
+		err = fmt.Errorf("`git hash-object' failed: %v", werr)
+	}
+	return strings.TrimSpace(out.String()), err
+}
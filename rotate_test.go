@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"polydawn.net/grypt/grypt"
+)
+
+func TestReencrypt(t *testing.T) {
+	oldKey, newKey := keys[0], keys[1]
+	ct := new(bytes.Buffer)
+	if err := grypt.Encrypt(bytes.NewReader(plaintext), ct, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	for name, in := range map[string][]byte{"ciphertext": ct.Bytes(), "plaintext": plaintext} {
+		out := new(bytes.Buffer)
+		if err := reencrypt(bytes.NewReader(in), out, grypt.KeyRing{oldKey}, newKey); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		x := new(bytes.Buffer)
+		if err := grypt.Decrypt(out, x, newKey); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(x.Bytes(), plaintext) {
+			t.Errorf("%s: round trip failed", name)
+		}
+	}
+
+	// a damaged file must not be re-encrypted as if it were intact
+	damaged := ct.Bytes()[:ct.Len()-1]
+	if err := reencrypt(bytes.NewReader(damaged), new(bytes.Buffer), grypt.KeyRing{oldKey}, newKey); err != grypt.ErrUnverified {
+		t.Errorf("expected %q, got %v", grypt.ErrUnverified, err)
+	}
+
+	// when encrypting fails early, r is no longer read once reencrypt
+	// returns; the caller drains it next
+	r := &watchedReader{t: t, r: bytes.NewReader(ct.Bytes())}
+	badKey := grypt.Key{Version: grypt.KeyV2, Scheme: newKey.Scheme, Secret: []byte("short")}
+	if err := reencrypt(r, new(bytes.Buffer), grypt.KeyRing{oldKey}, badKey); err == nil {
+		t.Error("re-encrypted to a malformed key")
+	}
+	atomic.StoreInt32(&r.returned, 1)
+	time.Sleep(10 * time.Millisecond)
+}
+
+// a reader that fails the test if it is read after 'returned' is set
+type watchedReader struct {
+	t        *testing.T
+	r        io.Reader
+	returned int32
+}
+
+func (w *watchedReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&w.returned) != 0 {
+		w.t.Error("read after reencrypt returned")
+	}
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	time.Sleep(time.Millisecond)
+	return w.r.Read(p)
+}
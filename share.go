@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// print -n shares of the keys in keyfile, one per line, any -k of which
+// give the keys back with `combine'
+func split() error {
+	ring, err := ReadKeyRing(keyfile)
+	if err != nil {
+		return err
+	}
+	shares, err := grypt.SplitKeyRing(rand.Reader, ring, *shareCount, *shareThreshold)
+	if err != nil {
+		return err
+	}
+	for _, s := range shares {
+		bits, err := grypt.MarshalShare(s)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(bits)
+	}
+	fmt.Fprintf(os.Stderr, "%d shares of key %x, each a line; %d of them are needed\n",
+		len(shares), ring[0].ID(), *shareThreshold)
+	return nil
+}
+
+// write the keys rebuilt from the shares in 'files' into keyfile, which must
+// not exist yet. Without files the shares are read from stdin, one per line.
+func combine(files []string) error {
+	return writeNewKeyFile(func() (grypt.KeyRing, error) {
+		shares, err := readShares(files)
+		if err != nil {
+			return nil, err
+		}
+		ring, err := grypt.CombineShares(shares)
+		if err == grypt.ErrTooFewShares && len(shares) > 0 {
+			return nil, fmt.Errorf("%v: %d different ones are needed", err, shares[0].Threshold)
+		}
+		return ring, err
+	})
+}
+
+// read the shares in 'files', or with none given, from stdin, one per line
+func readShares(files []string) ([]grypt.Share, error) {
+	var shares []grypt.Share
+	for _, f := range files {
+		bits, err := readKeyFile(f)
+		if err != nil {
+			return nil, err
+		}
+		s, err := grypt.UnmarshalShare(bits)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		shares = append(shares, s)
+	}
+	if len(files) != 0 {
+		return shares, nil
+	}
+	in := bufio.NewScanner(os.Stdin)
+	for n := 1; in.Scan(); n++ {
+		if strings.TrimSpace(in.Text()) == "" {
+			continue
+		}
+		s, err := grypt.UnmarshalShare(in.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		shares = append(shares, s)
+	}
+	return shares, in.Err()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"polydawn.net/grypt/grypt"
+)
+
+type (
+	// What `grypt status' reports for a path with filter=grypt
+	pathStatus struct {
+		Path string `json:"path"`
+		// The blob in HEAD and in the index, if any
+		Committed *blobStatus `json:"committed"`
+		Staged    *blobStatus `json:"staged"`
+		// How the working copy differs from HEAD: unchanged, modified,
+		// deleted or added
+		WorkTree string `json:"worktree"`
+	}
+	blobStatus struct {
+		Blob      string   `json:"blob"`
+		Kind      blobKind `json:"-"`
+		Encrypted bool     `json:"encrypted"`
+		// In the format of the first grypt
+		Legacy bool `json:"legacy,omitempty"`
+		// Why the header could not be read, if it starts like one, or why
+		// it could not be decrypted
+		Error      string `json:"error,omitempty"`
+		Scheme     string `json:"scheme,omitempty"`
+		KeyID      string `json:"key_id,omitempty"`
+		Recipients int    `json:"recipients,omitempty"`
+	}
+
+	// How a blob meant for grypt is stored, as told by classify
+	blobKind int
+)
+
+const (
+	blobPlaintext blobKind = iota
+	blobEncrypted
+	// in the format of the first grypt, see grypt.IsLegacy
+	blobLegacy
+	// starts like a grypt file, but its header can not be read, or it
+	// fails to verify
+	blobInvalid
+	// encrypted, but with none of the keys at hand
+	blobUnknownKey
+)
+
+// Room for the largest header ReadHeader accepts, with the magic before it
+const headerPeek = 64*1024 + 16
+
+// report on every path meant for grypt in HEAD, the index and the work tree
+func status() error {
+	if err := chdirTop(); err != nil {
+		return err
+	}
+	_, err := git("rev-parse", "--verify", "-q", "HEAD")
+	hasHEAD := err == nil
+	var committed []treeEntry
+	if hasHEAD {
+		if committed, err = lsTree("HEAD"); err != nil {
+			return err
+		}
+	}
+	staged, err := lsFiles()
+	if err != nil {
+		return err
+	}
+
+	byPath := make(map[string]*pathStatus)
+	var paths []string
+	entry := func(p string) *pathStatus {
+		s, ok := byPath[p]
+		if !ok {
+			s = &pathStatus{Path: p, WorkTree: "unchanged"}
+			byPath[p] = s
+			paths = append(paths, p)
+		}
+		return s
+	}
+	blobs, err := classifyBlobs(committed, nil)
+	if err != nil {
+		return err
+	}
+	for p, b := range blobs {
+		entry(p).Committed = b
+	}
+	if blobs, err = classifyBlobs(staged, nil); err != nil {
+		return err
+	}
+	for p, b := range blobs {
+		entry(p).Staged = b
+	}
+	sort.Strings(paths)
+
+	// the working copy against HEAD, run through the clean filter where
+	// the stat info has changed
+	diffArgs := []string{"diff-index", "-z", "--name-status", "HEAD"}
+	if !hasHEAD {
+		for _, p := range paths {
+			byPath[p].WorkTree = "added"
+		}
+		diffArgs = []string{"diff-files", "-z", "--name-status"}
+	}
+	out, err := git(diffArgs...)
+	if err != nil {
+		return err
+	}
+	fields := splitZ(out)
+	for i := 0; i+1 < len(fields); i += 2 {
+		s, ok := byPath[fields[i+1]]
+		if !ok {
+			continue
+		}
+		switch fields[i] {
+		case "A":
+			s.WorkTree = "added"
+		case "D":
+			s.WorkTree = "deleted"
+		default:
+			s.WorkTree = "modified"
+		}
+	}
+
+	report := make([]*pathStatus, len(paths))
+	for n, p := range paths {
+		report[n] = byPath[p]
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		return enc.Encode(report)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tCOMMITTED\tSTAGED\tWORKTREE")
+	for _, s := range report {
+		staged := s.Staged.String()
+		if s.Staged != nil && s.Committed != nil && s.Staged.Blob == s.Committed.Blob {
+			staged = "same"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Path, s.Committed, staged, s.WorkTree)
+	}
+	return w.Flush()
+}
+
+// The blobs of those 'entries' that are meant for grypt, by path, each
+// filled in by classify. Paths that share a blob share its blobStatus.
+// Only regular files are filtered by git, so nothing else is classified.
+func classifyBlobs(entries []treeEntry, ring grypt.KeyRing) (map[string]*blobStatus, error) {
+	blobs := make(map[string]string, len(entries))
+	paths := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Mode != "100644" && e.Mode != "100755" {
+			continue
+		}
+		blobs[e.Path] = e.Blob
+		paths = append(paths, e.Path)
+	}
+	paths, err := grypted(paths)
+	if err != nil {
+		return nil, err
+	}
+	cat, err := newCatFile()
+	if err != nil {
+		return nil, err
+	}
+	defer cat.Close()
+	byBlob := make(map[string]*blobStatus)
+	byPath := make(map[string]*blobStatus, len(paths))
+	for _, p := range paths {
+		b, ok := byBlob[blobs[p]]
+		if !ok {
+			b = &blobStatus{Blob: blobs[p]}
+			err := cat.Read(b.Blob, func(r io.Reader) error {
+				return b.classify(r, ring)
+			})
+			if err != nil {
+				return nil, err
+			}
+			byBlob[b.Blob] = b
+		}
+		byPath[p] = b
+	}
+	return byPath, nil
+}
+
+// fill in b from its blob, read from r. This is the one place that decides
+// whether a blob is encrypted; status, audit and the pre-commit hook all go
+// by it. With keys, encrypted blobs are also decrypted, to tell whether any
+// of them opens it.
+func (b *blobStatus) classify(r io.Reader, ring grypt.KeyRing) error {
+	br := bufio.NewReaderSize(r, headerPeek)
+	switch {
+	case grypt.IsLegacy(br):
+		b.Kind, b.Encrypted, b.Legacy = blobLegacy, true, true
+	case grypt.IsEncrypted(br):
+		b.Kind, b.Encrypted = blobEncrypted, true
+		head, _ := br.Peek(headerPeek)
+		h, _, err := grypt.ReadHeader(bytes.NewReader(head))
+		if err != nil {
+			b.Kind, b.Error = blobInvalid, err.Error()
+			return nil
+		}
+		b.Scheme = h.Scheme.String()
+		b.KeyID = hex.EncodeToString(h.KeyID)
+		b.Recipients = len(h.Recipients)
+	default:
+		b.Kind = blobPlaintext
+		return nil
+	}
+	if ring == nil {
+		return nil
+	}
+	switch err := grypt.DecryptRing(br, ioutil.Discard, ring); err {
+	case nil:
+	case grypt.ErrLegacy, grypt.ErrWrongScheme, grypt.ErrUnknownKey, grypt.ErrNotRecipient:
+		b.Kind, b.Error = blobUnknownKey, err.Error()
+	default:
+		b.Kind, b.Error = blobInvalid, err.Error()
+	}
+	return nil
+}
+
+func (b *blobStatus) String() string {
+	switch {
+	case b == nil:
+		return "-"
+	case b.Kind == blobPlaintext:
+		return "PLAINTEXT"
+	case b.Kind == blobUnknownKey:
+		return "unknown key: " + b.Error
+	case b.Kind == blobInvalid:
+		return "invalid: " + b.Error
+	case b.Kind == blobLegacy:
+		return "original format"
+	case b.Recipients != 0:
+		return fmt.Sprintf("%s, %d recipients", b.Scheme, b.Recipients)
+	case b.KeyID != "":
+		return fmt.Sprintf("%s key %s", b.Scheme, b.KeyID)
+	}
+	return b.Scheme
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"polydawn.net/grypt/grypt"
+)
+
+// add a public key to RecipientsFile and re-encrypt every file to it
+func addUser(key, name string) error {
+	pub, err := grypt.ParsePublicKey(key)
+	if err != nil {
+		return err
+	}
+	if err = chdirTop(); err != nil {
+		return err
+	}
+	if err = requireClean("add-user"); err != nil {
+		return err
+	}
+	existing, err := ReadRecipients(RecipientsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, p := range existing {
+		if p == pub {
+			return fmt.Errorf("%s is already a recipient", pub)
+		}
+	}
+
+	line := pub.String()
+	if name != "" {
+		line += " " + name
+	}
+	f, err := os.OpenFile(RecipientsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, line)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return restage()
+}
+
+// remove a recipient, by public key or name, from RecipientsFile and
+// re-encrypt every file under new data keys
+func removeUser(who string) error {
+	if who == "" {
+		return fmt.Errorf("no recipient given")
+	}
+	if err := chdirTop(); err != nil {
+		return err
+	}
+	if err := requireClean("remove-user"); err != nil {
+		return err
+	}
+	bits, err := ioutil.ReadFile(RecipientsFile)
+	if err != nil {
+		return err
+	}
+	var kept []string
+	removed := 0
+	for _, line := range strings.SplitAfter(string(bits), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (fields[0] == who || strings.Join(fields[1:], " ") == who) {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return fmt.Errorf("%s is not a recipient", who)
+	}
+	if err = ioutil.WriteFile(RecipientsFile, []byte(strings.Join(kept, "")), 0644); err != nil {
+		return err
+	}
+	if err = restage(); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "note: earlier commits can still be read by the removed recipient")
+	return nil
+}
+
+// stage RecipientsFile and run every tracked file through the clean filter
+// again, so that the index is encrypted to the current recipients. The work
+// tree must not have other changes, or they would be staged as well.
+func restage() error {
+	if _, err := git("add", "--", RecipientsFile); err != nil {
+		return err
+	}
+	_, err := git("add", "--renormalize", "--", ".")
+	return err
+}